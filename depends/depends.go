@@ -0,0 +1,236 @@
+// Package depends builds the ports dependency graph from *_DEPENDS variables
+// of port Makefiles, without running make(1).
+package depends
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+	"github.com/dmgk/portgrep/makefile"
+)
+
+// Edge is a dependency of port From on port To.
+type Edge struct {
+	From string
+	To   string
+	// Kind is the dependency kind, one of makefile.Kinds
+	Kind string
+	// Flavor is the flavor of To required by From, if any
+	Flavor string
+	// Var is the name of the variable declaring the dependency
+	Var string
+}
+
+// Graph is the dependency graph of ports, identified by their origins.
+type Graph struct {
+	mu    sync.Mutex // protects fields below while graph is being built
+	ports map[string]struct{}
+	deps  map[string][]*Edge // edges by From
+	rdeps map[string][]*Edge // edges by To
+}
+
+// ErrorFunc is called by Build for ports or categories that can't be read.
+// If it returns nil, they are left out of the graph and Build continues.
+type ErrorFunc func(path string, err error) error
+
+// Build returns the dependency graph of ports in categories, or of all ports
+// if categories is empty.  Port Makefiles are read like grep.Walk does, with
+// included files and master ports followed.  Dependencies on ports outside
+// of categories are part of the graph too.  If fr is not nil, port files are
+// read using it.  Read errors are passed to efn, or abort Build if efn is
+// nil.
+func Build(portsRoot string, categories []string, fr grep.FileReader, efn ErrorFunc, maxJobs int) (*Graph, error) {
+	g := &Graph{
+		ports: make(map[string]struct{}),
+		deps:  make(map[string][]*Edge),
+		rdeps: make(map[string][]*Edge),
+	}
+
+	dfn := func(origin string, ds []*makefile.Dependency) error {
+		g.add(origin, ds)
+		return nil
+	}
+	if err := walk(portsRoot, categories, fr, efn, dfn, maxJobs); err != nil {
+		return nil, err
+	}
+
+	for _, es := range g.rdeps {
+		sortEdges(es)
+	}
+	return g, nil
+}
+
+// HasDependents reports whether any port in categories, or any port if
+// categories is empty, depends directly on the port origin.  Unlike Build, it
+// stops reading ports as soon as the first dependent is found.  Other
+// arguments are the same as Build's.
+func HasDependents(portsRoot string, categories []string, fr grep.FileReader, origin string, efn ErrorFunc, maxJobs int) (bool, error) {
+	found := false
+	dfn := func(from string, ds []*makefile.Dependency) error {
+		for _, d := range ds {
+			if d.Origin == origin {
+				found = true
+				return grep.Stop
+			}
+		}
+		return nil
+	}
+	if err := walk(portsRoot, categories, fr, efn, dfn, maxJobs); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// walk reads ports like Build does and calls dfn with the origin and
+// dependencies of each of them.  dfn can return grep.Stop to terminate the
+// walk early.
+func walk(portsRoot string, categories []string, fr grep.FileReader, efn ErrorFunc, dfn func(origin string, ds []*makefile.Dependency) error, maxJobs int) error {
+	wfn := func(path string, texts []*grep.Text, err error) error {
+		if err != nil {
+			if efn != nil {
+				return efn(path, err)
+			}
+			return err
+		}
+		origin, err := filepath.Rel(portsRoot, path)
+		if err != nil {
+			return err
+		}
+		return dfn(filepath.ToSlash(origin), parse(texts))
+	}
+	return grep.Walk(portsRoot, categories, grep.DefaultFiles, fr, grep.GfollowIncludes, wfn, maxJobs)
+}
+
+// parse returns dependencies declared in port Makefile texts.  Assignments in
+// all texts are evaluated in order, as if the texts were included one after
+// another.
+func parse(texts []*grep.Text) []*makefile.Dependency {
+	vars := makefile.NewVars()
+
+	var as []*makefile.Assignment
+	for _, t := range texts {
+		if !grep.IsMakefile(t.Name) {
+			continue
+		}
+		for _, a := range makefile.ParseAssignments(t.Restore(t.Bytes())) {
+			vars.Apply(a)
+			as = append(as, a)
+		}
+	}
+
+	return makefile.ParseDependencies(as, vars)
+}
+
+func (g *Graph) add(origin string, ds []*makefile.Dependency) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.ports[origin] = struct{}{}
+
+	type key struct {
+		to, kind, flavor string
+	}
+	seen := make(map[key]struct{})
+
+	for _, d := range ds {
+		k := key{d.Origin, d.Kind, d.Flavor}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		e := &Edge{
+			From:   origin,
+			To:     d.Origin,
+			Kind:   d.Kind,
+			Flavor: d.Flavor,
+			Var:    d.Var,
+		}
+		g.deps[origin] = append(g.deps[origin], e)
+		g.rdeps[e.To] = append(g.rdeps[e.To], e)
+	}
+}
+
+// Ports returns sorted origins of all ports the graph was built from.
+func (g *Graph) Ports() []string {
+	res := make([]string, 0, len(g.ports))
+	for p := range g.ports {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Dependencies returns dependencies of the port origin.
+func (g *Graph) Dependencies(origin string) []*Edge {
+	return g.deps[origin]
+}
+
+// Dependents returns origins of ports depending directly on the port origin,
+// grouped by dependency kind.
+func (g *Graph) Dependents(origin string) map[string][]string {
+	res := make(map[string][]string)
+	for _, e := range g.rdeps[origin] {
+		res[e.Kind] = appendUnique(res[e.Kind], e.From)
+	}
+	return res
+}
+
+// TransitiveDependents returns origins of ports depending on the port origin
+// directly or indirectly, grouped by the kind of their dependencies on the
+// port origin or on any of its dependents.
+func (g *Graph) TransitiveDependents(origin string) map[string][]string {
+	closure := map[string]struct{}{origin: {}}
+	queue := []string{origin}
+	for len(queue) > 0 {
+		o := queue[0]
+		queue = queue[1:]
+		for _, e := range g.rdeps[o] {
+			if _, ok := closure[e.From]; !ok {
+				closure[e.From] = struct{}{}
+				queue = append(queue, e.From)
+			}
+		}
+	}
+
+	kinds := make(map[string]map[string]struct{})
+	for o := range closure {
+		for _, e := range g.rdeps[o] {
+			if e.From == origin {
+				continue
+			}
+			if kinds[e.Kind] == nil {
+				kinds[e.Kind] = make(map[string]struct{})
+			}
+			kinds[e.Kind][e.From] = struct{}{}
+		}
+	}
+
+	res := make(map[string][]string, len(kinds))
+	for k, set := range kinds {
+		for o := range set {
+			res[k] = append(res[k], o)
+		}
+		sort.Strings(res[k])
+	}
+	return res
+}
+
+// appendUnique appends s to sorted ss, unless ss already ends with it.
+func appendUnique(ss []string, s string) []string {
+	if n := len(ss); n > 0 && ss[n-1] == s {
+		return ss
+	}
+	return append(ss, s)
+}
+
+func sortEdges(es []*Edge) {
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].From != es[j].From {
+			return es[i].From < es[j].From
+		}
+		return es[i].Kind < es[j].Kind
+	})
+}
@@ -0,0 +1,101 @@
+package depends
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDependents(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile":       "PORTNAME=	foo\n",
+		"devel/bar/Makefile":       "PORTNAME=	bar\nLIB_DEPENDS=	libfoo.so:devel/foo\n",
+		"devel/bar-nox11/Makefile": "MASTERDIR=	${.CURDIR}/../bar\n.include \"${MASTERDIR}/Makefile\"\n",
+		"devel/foobar/Makefile":    "PORTNAME=	foobar\nBUILD_DEPENDS=	foo:devel/foo\n",
+		"lang/baz/Makefile":        "PORTNAME=	baz\nRUN_DEPENDS=	bar:devel/bar \\\n\t\tfoobar:devel/foobar@py39\n",
+		"lang/qux/Makefile":        "PORTNAME=	qux\nTEST_DEPENDS=	baz:lang/baz\n",
+	})
+
+	g, err := Build(root, nil, nil, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"build": {"devel/foobar"},
+		"lib":   {"devel/bar", "devel/bar-nox11"},
+	}
+	if deps := g.Dependents("devel/foo"); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected dependents %v, got %v", expected, deps)
+	}
+
+	expected = map[string][]string{
+		"build": {"devel/foobar"},
+		"lib":   {"devel/bar", "devel/bar-nox11"},
+		"run":   {"lang/baz"},
+		"test":  {"lang/qux"},
+	}
+	if deps := g.TransitiveDependents("devel/foo"); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected transitive dependents %v, got %v", expected, deps)
+	}
+
+	g, err = Build(root, []string{"lang"}, nil, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{
+		"run": {"lang/baz"},
+	}
+	if deps := g.Dependents("devel/bar"); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected dependents in lang %v, got %v", expected, deps)
+	}
+
+	for _, tc := range []struct {
+		origin     string
+		categories []string
+		expected   bool
+	}{
+		{"devel/foo", nil, true},
+		{"devel/foo", []string{"lang"}, false},
+		{"lang/qux", nil, false},
+	} {
+		ok, err := HasDependents(root, tc.categories, nil, tc.origin, nil, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.expected {
+			t.Errorf("expected %s in %v to have dependents: %t, got %t", tc.origin, tc.categories, tc.expected, ok)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile": "LIB_DEPENDS=	libbar.so:devel/bar\n",
+		"devel/loop/":        "",
+	})
+	if err := os.Symlink("Makefile", filepath.Join(root, "devel/loop/Makefile")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(root, nil, nil, nil, 2); err == nil {
+		t.Error("expected Build to fail without ErrorFunc")
+	}
+
+	var failed []string
+	efn := func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}
+	g, err := Build(root, nil, nil, efn, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{filepath.Join(root, "devel/loop")}; !reflect.DeepEqual(failed, exp) {
+		t.Errorf("expected errors for %v, got %v", exp, failed)
+	}
+	if exp := []string{"devel/foo"}; !reflect.DeepEqual(g.Ports(), exp) {
+		t.Errorf("expected ports %v, got %v", exp, g.Ports())
+	}
+}
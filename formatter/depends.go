@@ -0,0 +1,98 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
+)
+
+// WriteDependents writes origins of dependent ports grouped by dependency
+// kind, as returned by depends.Graph.Dependents, in text format.  Origins of
+// each kind are indented with indent.  If ForiginsOnly or ForiginsSingleLine
+// flag is set, only distinct origins are written, one per line or all on one
+// line.
+func WriteDependents(w io.Writer, deps map[string][]string, indent string, flags int) error {
+	buf := getBuf()
+	defer putBuf(buf)
+
+	if flags&(ForiginsOnly|ForiginsSingleLine) != 0 {
+		origins := mergeOrigins(deps)
+		if len(origins) == 0 {
+			return nil
+		}
+		sep := "\n"
+		if flags&ForiginsSingleLine != 0 {
+			sep = " "
+		}
+		buf.WriteString(strings.Join(origins, sep))
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	for _, k := range makefile.Kinds {
+		origins := deps[k]
+		if len(origins) == 0 {
+			continue
+		}
+		writeColor(buf, k, cquery, flags)
+		buf.WriteString(":\n")
+		for _, o := range origins {
+			buf.WriteString(indent)
+			writeColor(buf, o, cpath, flags)
+			buf.WriteByte('\n')
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type jsonDependents struct {
+	Origin     string              `json:"origin"`
+	Dependents map[string][]string `json:"dependents"`
+}
+
+// WriteDependentsJSON writes origins of ports depending on origin grouped by
+// dependency kind as a single line JSON object.
+func WriteDependentsJSON(w io.Writer, origin string, deps map[string][]string) error {
+	if deps == nil {
+		deps = map[string][]string{}
+	}
+	b, err := json.Marshal(&jsonDependents{origin, deps})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// mergeOrigins returns sorted distinct origins of all kinds in deps.
+func mergeOrigins(deps map[string][]string) []string {
+	set := make(map[string]struct{})
+	for _, origins := range deps {
+		for _, o := range origins {
+			set[o] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for o := range set {
+		res = append(res, o)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func writeColor(buf *bytes.Buffer, s string, c, flags int) {
+	if flags&Fcolor != 0 {
+		buf.WriteString(colors[c])
+		buf.WriteString(s)
+		buf.WriteString(creset)
+	} else {
+		buf.WriteString(s)
+	}
+}
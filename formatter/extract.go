@@ -0,0 +1,121 @@
+package formatter
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+type extractFormatter struct {
+	mu sync.Mutex // protects w and counts
+	w  io.Writer
+
+	root   string
+	flags  int
+	counts map[string]int // value counts, if aggregating
+}
+
+// NewExtract returns a formatter that writes one origin<TAB>value line per
+// result submatch.  If unique is true, values are aggregated instead and End
+// writes one count<TAB>value line per distinct value, most frequent first.
+func NewExtract(w io.Writer, root string, flags int, unique bool) Formatter {
+	f := &extractFormatter{
+		w:     w,
+		root:  root,
+		flags: flags,
+	}
+	if !strings.HasSuffix(root, "/") {
+		f.root = f.root + "/"
+	}
+	if unique {
+		f.counts = make(map[string]int)
+	}
+	return f
+}
+
+func (f *extractFormatter) SetIndent(indent string) {
+	// noop
+}
+
+func (f *extractFormatter) Begin() error {
+	return nil
+}
+
+func (f *extractFormatter) End() error {
+	if f.counts == nil {
+		return nil
+	}
+
+	values := make([]string, 0, len(f.counts))
+	for v := range f.counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		ci, cj := f.counts[values[i]], f.counts[values[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return values[i] < values[j]
+	})
+
+	buf := getBuf()
+	defer putBuf(buf)
+	for _, v := range values {
+		buf.WriteString(strconv.Itoa(f.counts[v]))
+		buf.WriteByte('\t')
+		writeColor(buf, v, cmatch, f.flags)
+		buf.WriteByte('\n')
+	}
+	_, err := f.w.Write(buf.Bytes())
+	return err
+}
+
+func (f *extractFormatter) Format(path string, results grep.Results) error {
+	if f.flags&FstripRoot != 0 {
+		path = strings.TrimPrefix(path, f.root)
+	}
+
+	if f.counts != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, m := range results {
+			for _, v := range resultValues(m) {
+				f.counts[v]++
+			}
+		}
+		return nil
+	}
+
+	buf := getBuf()
+	defer putBuf(buf)
+	for _, m := range results {
+		for _, v := range resultValues(m) {
+			writeColor(buf, path, cpath, f.flags)
+			buf.WriteByte('\t')
+			writeColor(buf, v, cmatch, f.flags)
+			buf.WriteByte('\n')
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.w.Write(buf.Bytes())
+	return err
+}
+
+// resultValues returns result submatches of m.  Continuation lines in values
+// are joined and whitespace is collapsed to single spaces.
+func resultValues(m *grep.Result) []string {
+	var res []string
+	for i := 0; i+1 < len(m.ResultSubmatch); i += 2 {
+		v := m.Text[m.ResultSubmatch[i]:m.ResultSubmatch[i+1]]
+		v = bytes.ReplaceAll(v, []byte("\\\n"), []byte{' '})
+		res = append(res, strings.Join(strings.Fields(string(v)), " "))
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+package formatter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+func TestExtract(t *testing.T) {
+	ports := []struct {
+		path    string
+		results grep.Results
+	}{
+		{"/ports/devel/foo", grep.Results{
+			{Text: []byte("USES=\tgo gmake\n"), ResultSubmatch: []int{6, 8, 9, 14}},
+		}},
+		{"/ports/lang/bar", grep.Results{
+			{Text: []byte("USES=\tgo\n"), ResultSubmatch: []int{6, 8}},
+			// values spanning continuation lines are joined
+			{Text: []byte("FOO=\ta \\\n\t\tb\n"), ResultSubmatch: []int{5, 12}},
+		}},
+	}
+
+	examples := []struct {
+		unique bool
+		output string
+	}{
+		{false, "devel/foo\tgo\ndevel/foo\tgmake\nlang/bar\tgo\nlang/bar\ta b\n"},
+		// most frequent first, then by value
+		{true, "2\tgo\n1\ta b\n1\tgmake\n"},
+	}
+
+	for i, x := range examples {
+		var buf bytes.Buffer
+		f := NewExtract(&buf, "/ports", FstripRoot, x.unique)
+		if err := f.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ports {
+			if err := f.Format(p.path, p.results); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.End(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.output {
+			t.Errorf("[%d] expected output\n%s\ngot\n%s", i, x.output, buf.String())
+		}
+	}
+}
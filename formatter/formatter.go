@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 
@@ -15,6 +16,8 @@ const (
 	ForiginsOnly
 	ForiginsSingleLine
 	FstripRoot
+	FfileNames
+	Fflavors
 
 	Fdefaults = FstripRoot
 )
@@ -61,9 +64,13 @@ func SetColors(c string) {
 	}
 }
 
+// Formatter formats search results.  Begin is called once before the first
+// call to Format and End is called once after the search is complete.
 type Formatter interface {
 	SetIndent(indent string)
+	Begin() error
 	Format(path string, matches grep.Results) error
+	End() error
 }
 
 type textFormatter struct {
@@ -92,6 +99,14 @@ func (f *textFormatter) SetIndent(indent string) {
 	f.indent = indent
 }
 
+func (f *textFormatter) Begin() error {
+	return nil
+}
+
+func (f *textFormatter) End() error {
+	return nil
+}
+
 func (f *textFormatter) Format(path string, results grep.Results) error {
 	buf := getBuf()
 	defer putBuf(buf)
@@ -104,32 +119,49 @@ func (f *textFormatter) Format(path string, results grep.Results) error {
 		if f.needSep {
 			buf.WriteByte(' ')
 		}
-		buf.WriteString(path)
+		buf.WriteString(strings.Join(origins(path, results, f.flags), " "))
 		f.needSep = true
 		return f.write(buf)
 	}
 
 	if f.flags&ForiginsOnly != 0 {
-		buf.WriteString(path)
+		buf.WriteString(strings.Join(origins(path, results, f.flags), "\n"))
 		buf.WriteByte('\n')
 		return f.write(buf)
 	}
 
 	if results != nil {
-		if f.flags&Fcolor != 0 {
-			buf.WriteString(colors[cpath])
-			buf.WriteString(path)
-			buf.WriteString(creset)
-		} else {
-			buf.WriteString(path)
-		}
+		writeColor(buf, strings.Join(origins(path, results, f.flags), " "), cpath, f.flags)
 		buf.WriteString(":\n")
 
+		indent := f.indent
+		if f.flags&FfileNames != 0 {
+			indent += f.indent
+		}
+
 		for i, m := range results {
 			formatBuf := getBuf()
 			defer putBuf(formatBuf)
 
-			if i > 0 {
+			newFile := i == 0 || m.File != results[i-1].File
+			if f.flags&FfileNames != 0 && newFile {
+				buf.WriteString(f.indent)
+				if f.flags&Fcolor != 0 {
+					buf.WriteString(colors[cpath])
+					buf.WriteString(m.File)
+					buf.WriteString(creset)
+				} else {
+					buf.WriteString(m.File)
+				}
+				buf.WriteString(":\n")
+			}
+
+			header := resultHeader(m)
+			// like grep(1), print results with contiguous text as one block,
+			// unless they apply under different conditions
+			contiguous := !newFile && m.Offset == results[i-1].Offset+len(results[i-1].Text) &&
+				header == resultHeader(results[i-1])
+			if !newFile && !contiguous {
 				if f.flags&Fcolor != 0 {
 					formatBuf.WriteString(colors[cseparator])
 					formatBuf.WriteString("--------\n")
@@ -139,30 +171,21 @@ func (f *textFormatter) Format(path string, results grep.Results) error {
 				}
 			}
 
+			if header != "" && !contiguous {
+				writeColor(formatBuf, header, cseparator, f.flags)
+				formatBuf.WriteByte('\n')
+			}
+
 			if f.flags&Fcolor != 0 {
-				if m.QuerySubmatch != nil {
-					formatBuf.Write(m.Text[:m.QuerySubmatch[0]])
-					formatBuf.WriteString(colors[cquery])
-					formatBuf.Write(m.Text[m.QuerySubmatch[0]:m.QuerySubmatch[1]])
-					formatBuf.WriteString(creset)
-				}
-				if m.QuerySubmatch != nil && m.ResultSubmatch != nil {
-					formatBuf.Write(m.Text[m.QuerySubmatch[1]:m.ResultSubmatch[0]])
-				}
-				if m.ResultSubmatch != nil {
-					formatBuf.WriteString(colors[cmatch])
-					formatBuf.Write(m.Text[m.ResultSubmatch[0]:m.ResultSubmatch[1]])
-					formatBuf.WriteString(creset)
-					formatBuf.Write(m.Text[m.ResultSubmatch[1]:])
-				}
+				writeColored(formatBuf, m)
 			} else {
 				formatBuf.Write(m.Text)
 			}
 
-			if f.indent != "" {
+			if indent != "" {
 				sc := bufio.NewScanner(formatBuf)
 				for sc.Scan() {
-					buf.WriteString(f.indent)
+					buf.WriteString(indent)
 					buf.WriteString(sc.Text())
 					buf.WriteByte('\n')
 				}
@@ -174,7 +197,115 @@ func (f *textFormatter) Format(path string, results grep.Results) error {
 		return f.write(buf)
 	}
 
-	return nil
+	// ports matched only by negated searches have no results
+	if f.flags&Fcolor != 0 {
+		buf.WriteString(colors[cpath])
+		buf.WriteString(path)
+		buf.WriteString(creset)
+	} else {
+		buf.WriteString(path)
+	}
+	buf.WriteByte('\n')
+	return f.write(buf)
+}
+
+// origins returns the port origin path with "@flavor" suffixes of flavors
+// results apply to, if all of them are restricted to some port flavors.  If
+// Fflavors flag is set, all flavors the results apply to are listed.
+func origins(path string, results grep.Results, flags int) []string {
+	flavors := matchedFlavors(results, flags&Fflavors != 0)
+	if len(flavors) == 0 {
+		return []string{path}
+	}
+	res := make([]string, len(flavors))
+	for i, fl := range flavors {
+		res[i] = path + "@" + fl
+	}
+	return res
+}
+
+// matchedFlavors returns port flavors results apply to, in FLAVORS order.  If
+// all is false, it returns nil unless all results are restricted to some
+// flavors.
+func matchedFlavors(results grep.Results, all bool) []string {
+	set := make(map[string]struct{})
+	var portFlavors []string
+	for _, m := range results {
+		if m.Flavors == nil {
+			if !all {
+				return nil
+			}
+			for _, fl := range m.PortFlavors {
+				set[fl] = struct{}{}
+			}
+		}
+		for _, fl := range m.Flavors {
+			set[fl] = struct{}{}
+		}
+		if len(m.PortFlavors) > len(portFlavors) {
+			portFlavors = m.PortFlavors
+		}
+	}
+
+	var res []string
+	for _, fl := range portFlavors {
+		if _, ok := set[fl]; ok {
+			res = append(res, fl)
+		}
+	}
+	return res
+}
+
+// resultHeader returns the header line of results inside conditionals,
+// pulled in by options or restricted to flavors, or "".
+func resultHeader(m *grep.Result) string {
+	var parts []string
+	if len(m.Conditions) > 0 {
+		parts = append(parts, strings.Join(m.Conditions, " > "))
+	}
+	if m.Option != "" {
+		parts = append(parts, "(option "+m.Option+")")
+	}
+	if len(m.Flavors) > 0 {
+		parts = append(parts, "(flavor "+strings.Join(m.Flavors, " ")+")")
+	}
+	if parts == nil {
+		return ""
+	}
+	return strings.Join(parts, " ") + ":"
+}
+
+// writeColored writes match text to buf with query and result submatches
+// highlighted.
+func writeColored(buf *bytes.Buffer, m *grep.Result) {
+	type span struct {
+		start, end int
+		color      string
+	}
+
+	var spans []span
+	for i := 0; i+1 < len(m.QuerySubmatch); i += 2 {
+		spans = append(spans, span{m.QuerySubmatch[i], m.QuerySubmatch[i+1], colors[cquery]})
+	}
+	for i := 0; i+1 < len(m.ResultSubmatch); i += 2 {
+		spans = append(spans, span{m.ResultSubmatch[i], m.ResultSubmatch[i+1], colors[cmatch]})
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			continue // overlaps with the previous submatch
+		}
+		buf.Write(m.Text[pos:s.start])
+		buf.WriteString(s.color)
+		buf.Write(m.Text[s.start:s.end])
+		buf.WriteString(creset)
+		pos = s.end
+	}
+	buf.Write(m.Text[pos:])
 }
 
 func (f *textFormatter) write(buf *bytes.Buffer) error {
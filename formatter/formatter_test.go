@@ -0,0 +1,55 @@
+package formatter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+func TestTextSeparators(t *testing.T) {
+	text := "USES=	gmake\nUSE_GCC=	yes\n.if ${PORT_OPTIONS:MX}\nUSES+=	gmake\n.endif\nBUILD_DEPENDS=	gmake:devel/gmake\n"
+	result := func(line, lines int, conds ...string) *grep.Result {
+		start := 0
+		for i := 1; i < line; i++ {
+			start += bytes.IndexByte([]byte(text[start:]), '\n') + 1
+		}
+		end := start
+		for i := 0; i < lines; i++ {
+			end += bytes.IndexByte([]byte(text[end:]), '\n') + 1
+		}
+		return &grep.Result{
+			Text:       []byte(text[start:end]),
+			File:       "Makefile",
+			Offset:     start,
+			Line:       line,
+			Conditions: conds,
+		}
+	}
+
+	// results on lines 1 and 2 are contiguous and printed as one block,
+	// line 4 applies under a conditional and line 6 is not contiguous
+	var buf bytes.Buffer
+	f := NewText(&buf, "", 0)
+	err := f.Format("devel/foo", grep.Results{
+		result(1, 1),
+		result(2, 1),
+		result(4, 1, ".if ${PORT_OPTIONS:MX}"),
+		result(6, 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "devel/foo:\n" +
+		"USES=\tgmake\n" +
+		"USE_GCC=\tyes\n" +
+		"--------\n" +
+		".if ${PORT_OPTIONS:MX}:\n" +
+		"USES+=\tgmake\n" +
+		"--------\n" +
+		"BUILD_DEPENDS=\tgmake:devel/gmake\n"
+	if buf.String() != exp {
+		t.Errorf("expected output\n%s\ngot\n%s", exp, buf.String())
+	}
+}
@@ -0,0 +1,99 @@
+package formatter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dmgk/portgrep/depends"
+	"github.com/dmgk/portgrep/makefile"
+)
+
+// WriteGraphDOT writes dependencies of ports listed in origins as a Graphviz
+// DOT digraph.  Edges are labelled with dependency kinds.
+func WriteGraphDOT(w io.Writer, g *depends.Graph, origins []string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph ports {")
+	for _, o := range origins {
+		adj := adjacency(g, o)
+		if len(adj) == 0 {
+			fmt.Fprintf(bw, "\t%q;\n", o)
+			continue
+		}
+		for _, to := range sortedKeys(adj) {
+			fmt.Fprintf(bw, "\t%q -> %q [label=%q];\n", o, to, strings.Join(adj[to], ","))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// WriteGraphJSON writes dependencies of ports listed in origins as a JSON
+// object mapping each origin to its adjacency lists, one per dependency kind.
+func WriteGraphJSON(w io.Writer, g *depends.Graph, origins []string) error {
+	res := make(map[string]map[string][]string, len(origins))
+	for _, o := range origins {
+		kinds := make(map[string][]string)
+		for _, e := range g.Dependencies(o) {
+			kinds[e.Kind] = append(kinds[e.Kind], e.To)
+		}
+		for k, tos := range kinds {
+			kinds[k] = uniqueSorted(tos)
+		}
+		res[o] = kinds
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// adjacency returns dependencies of origin mapped to their kinds, in the
+// makefile.Kinds order.
+func adjacency(g *depends.Graph, origin string) map[string][]string {
+	order := make(map[string]int, len(makefile.Kinds))
+	for i, k := range makefile.Kinds {
+		order[k] = i
+	}
+
+	res := make(map[string][]string)
+	for _, e := range g.Dependencies(origin) {
+		res[e.To] = append(res[e.To], e.Kind)
+	}
+	for to, kinds := range res {
+		kinds = uniqueSorted(kinds)
+		sort.SliceStable(kinds, func(i, j int) bool {
+			return order[kinds[i]] < order[kinds[j]]
+		})
+		res[to] = kinds
+	}
+	return res
+}
+
+func sortedKeys(m map[string][]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func uniqueSorted(ss []string) []string {
+	sort.Strings(ss)
+	res := ss[:0]
+	for _, s := range ss {
+		if len(res) == 0 || s != res[len(res)-1] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package formatter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dmgk/portgrep/depends"
+)
+
+func TestGraph(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile": "PORTNAME=	foo\n",
+		"devel/bar/Makefile": "PORTNAME=	bar\nBUILD_DEPENDS=	foo:devel/foo\nLIB_DEPENDS=	libfoo.so:devel/foo\nRUN_DEPENDS=	${PYTHON_PKGNAMEPREFIX}baz>0:lang/baz@py39\n",
+		"lang/baz/Makefile":  "PORTNAME=	baz\n",
+		// quotes in origins are escaped
+		"x11/a\"b/Makefile": "PORTNAME=	ab\nRUN_DEPENDS=	bar:devel/bar\n",
+	})
+
+	g, err := depends.Build(root, nil, nil, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins := g.Ports()
+
+	examples := []struct {
+		write  func(*bytes.Buffer) error
+		output string
+	}{
+		{
+			func(buf *bytes.Buffer) error { return WriteGraphDOT(buf, g, origins) },
+			`digraph ports {
+	"devel/bar" -> "devel/foo" [label="build,lib"];
+	"devel/bar" -> "lang/baz" [label="run"];
+	"devel/foo";
+	"lang/baz";
+	"x11/a\"b" -> "devel/bar" [label="run"];
+}
+`,
+		},
+		{
+			func(buf *bytes.Buffer) error { return WriteGraphJSON(buf, g, origins) },
+			`{"devel/bar":{"build":["devel/foo"],"lib":["devel/foo"],"run":["lang/baz"]},"devel/foo":{},"lang/baz":{},"x11/a\"b":{"run":["devel/bar"]}}` + "\n",
+		},
+		{
+			func(buf *bytes.Buffer) error { return WriteGraphDOT(buf, g, nil) },
+			"digraph ports {\n}\n",
+		},
+	}
+
+	for i, x := range examples {
+		var buf bytes.Buffer
+		if err := x.write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.output {
+			t.Errorf("[%d] expected output\n%s\ngot\n%s", i, x.output, buf.String())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+// jsonResult is a single match result.  QuerySubmatch and ResultSubmatch are
+// byte offsets into Text.  encoding/json replaces invalid UTF-8 in Text with
+// U+FFFD, so the offsets may not line up with the written text if it's not
+// valid UTF-8.
+type jsonResult struct {
+	File           string   `json:"file"`
+	Text           string   `json:"text"`
+	QuerySubmatch  []int    `json:"query_submatch,omitempty"`
+	ResultSubmatch []int    `json:"result_submatch,omitempty"`
+	Offset         int      `json:"offset"`
+	Line           int      `json:"line"`
+	Column         int      `json:"column"`
+	Conditions     []string `json:"conditions,omitempty"`
+	Unexpanded     string   `json:"unexpanded,omitempty"`
+	Option         string   `json:"option,omitempty"`
+	Flavors        []string `json:"flavors,omitempty"`
+}
+
+type jsonPort struct {
+	Origin  string        `json:"origin"`
+	Path    string        `json:"path"`
+	Flavors []string      `json:"flavors,omitempty"`
+	Results []*jsonResult `json:"results,omitempty"`
+}
+
+type jsonFormatter struct {
+	mu sync.Mutex // protects w and needSep
+	w  io.Writer
+
+	root    string
+	flags   int
+	lines   bool
+	needSep bool
+	// nested is set if the array is a member of an enclosing object, End
+	// doesn't terminate it with a newline then
+	nested bool
+}
+
+// NewJSON returns a formatter that writes results as a single JSON array,
+// one object per matching port.
+func NewJSON(w io.Writer, root string, flags int) Formatter {
+	return newJSON(w, root, flags, false)
+}
+
+// NewNDJSON returns a formatter that writes results as newline delimited
+// JSON, one object per matching port per line.
+func NewNDJSON(w io.Writer, root string, flags int) Formatter {
+	return newJSON(w, root, flags, true)
+}
+
+func newJSON(w io.Writer, root string, flags int, lines bool) *jsonFormatter {
+	f := &jsonFormatter{
+		w:     w,
+		root:  root,
+		flags: flags,
+		lines: lines,
+	}
+	if !strings.HasSuffix(root, "/") {
+		f.root = f.root + "/"
+	}
+	return f
+}
+
+func (f *jsonFormatter) SetIndent(indent string) {
+	// noop, JSON output is never indented
+}
+
+func (f *jsonFormatter) Begin() error {
+	if f.lines {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := io.WriteString(f.w, "[")
+	return err
+}
+
+func (f *jsonFormatter) End() error {
+	if f.lines {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := "]"
+	if f.needSep {
+		s = "\n]"
+	}
+	if !f.nested {
+		s += "\n"
+	}
+	_, err := io.WriteString(f.w, s)
+	return err
+}
+
+func (f *jsonFormatter) Format(path string, results grep.Results) error {
+	buf := getBuf()
+	defer putBuf(buf)
+
+	origin := path
+	if f.flags&FstripRoot != 0 {
+		origin = strings.TrimPrefix(path, f.root)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	p := &jsonPort{
+		Origin:  origin,
+		Path:    abs,
+		Flavors: matchedFlavors(results, true),
+	}
+	if f.flags&(ForiginsOnly|ForiginsSingleLine) == 0 {
+		for _, r := range results {
+			p.Results = append(p.Results, &jsonResult{
+				File:           r.File,
+				Text:           string(r.Text),
+				QuerySubmatch:  r.QuerySubmatch,
+				ResultSubmatch: r.ResultSubmatch,
+				Offset:         r.Offset,
+				Line:           r.Line,
+				Column:         r.Column,
+				Conditions:     r.Conditions,
+				Unexpanded:     string(r.Unexpanded),
+				Option:         r.Option,
+				Flavors:        r.Flavors,
+			})
+		}
+	}
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+
+	return f.write(buf)
+}
+
+func (f *jsonFormatter) write(buf *bytes.Buffer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lines {
+		_, err := f.w.Write(buf.Bytes())
+		return err
+	}
+
+	// array elements are separated by a comma and written one per line
+	sep := "\n"
+	if f.needSep {
+		sep = ",\n"
+	}
+	f.needSep = true
+	if _, err := io.WriteString(f.w, sep); err != nil {
+		return err
+	}
+	_, err := f.w.Write(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
+	return err
+}
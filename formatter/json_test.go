@@ -0,0 +1,91 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+func TestJSON(t *testing.T) {
+	foo := grep.Results{{
+		Text:           []byte("USES=\tgo\n"),
+		QuerySubmatch:  []int{0, 4},
+		ResultSubmatch: []int{6, 8},
+		File:           "Makefile",
+		Line:           1,
+		Column:         7,
+	}}
+
+	examples := []struct {
+		lines  bool
+		ports  []string
+		output string
+	}{
+		{false, nil, "[]\n"},
+		{false, []string{"devel/foo"}, "[\n" +
+			`{"origin":"devel/foo","path":"/ports/devel/foo","results":[{"file":"Makefile","text":"USES=\tgo\n","query_submatch":[0,4],"result_submatch":[6,8],"offset":0,"line":1,"column":7}]}` +
+			"\n]\n"},
+		{false, []string{"devel/foo", "lang/bar"}, "[\n" +
+			`{"origin":"devel/foo","path":"/ports/devel/foo","results":[{"file":"Makefile","text":"USES=\tgo\n","query_submatch":[0,4],"result_submatch":[6,8],"offset":0,"line":1,"column":7}]},` + "\n" +
+			`{"origin":"lang/bar","path":"/ports/lang/bar","results":[{"file":"Makefile","text":"USES=\tgo\n","query_submatch":[0,4],"result_submatch":[6,8],"offset":0,"line":1,"column":7}]}` +
+			"\n]\n"},
+		{true, nil, ""},
+		{true, []string{"devel/foo", "lang/bar"},
+			`{"origin":"devel/foo","path":"/ports/devel/foo","results":[{"file":"Makefile","text":"USES=\tgo\n","query_submatch":[0,4],"result_submatch":[6,8],"offset":0,"line":1,"column":7}]}` + "\n" +
+				`{"origin":"lang/bar","path":"/ports/lang/bar","results":[{"file":"Makefile","text":"USES=\tgo\n","query_submatch":[0,4],"result_submatch":[6,8],"offset":0,"line":1,"column":7}]}` + "\n"},
+	}
+
+	for i, x := range examples {
+		var buf bytes.Buffer
+		f := NewJSON(&buf, "/ports", FstripRoot)
+		if x.lines {
+			f = NewNDJSON(&buf, "/ports", FstripRoot)
+		}
+		if err := f.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		for _, origin := range x.ports {
+			if err := f.Format("/ports/"+origin, foo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.End(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.output {
+			t.Errorf("[%d] expected output\n%s\ngot\n%s", i, x.output, buf.String())
+		}
+	}
+}
+
+func TestJSONSubmatchOffsets(t *testing.T) {
+	text := "MAINTAINER=\tjosé@example.org\n"
+	m := &grep.Result{
+		Text:           []byte(text),
+		QuerySubmatch:  []int{0, 10},
+		ResultSubmatch: []int{12, 29},
+		File:           "Makefile",
+	}
+
+	var buf bytes.Buffer
+	f := NewNDJSON(&buf, "/ports", FstripRoot)
+	if err := f.Format("/ports/devel/foo", grep.Results{m}); err != nil {
+		t.Fatal(err)
+	}
+
+	var p jsonPort
+	if err := json.Unmarshal(buf.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	r := p.Results[0]
+
+	// offsets index bytes of text, not runes
+	if s := r.Text[r.ResultSubmatch[0]:r.ResultSubmatch[1]]; s != "josé@example.org" {
+		t.Errorf("expected result submatch %q, got %q", "josé@example.org", s)
+	}
+	if s := r.Text[r.QuerySubmatch[0]:r.QuerySubmatch[1]]; s != "MAINTAINER" {
+		t.Errorf("expected query submatch %q, got %q", "MAINTAINER", s)
+	}
+}
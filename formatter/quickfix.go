@@ -0,0 +1,89 @@
+package formatter
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+type quickfixFormatter struct {
+	mu sync.Mutex // protects w
+	w  io.Writer
+}
+
+// NewQuickfix returns a formatter that writes one path:line:column:text line
+// per match, as understood by vim quickfix, Emacs grep-mode and similar
+// tools.  The text is the physical line the match starts on.
+func NewQuickfix(w io.Writer) Formatter {
+	return &quickfixFormatter{w: w}
+}
+
+func (f *quickfixFormatter) SetIndent(indent string) {
+	// noop
+}
+
+func (f *quickfixFormatter) Begin() error {
+	return nil
+}
+
+func (f *quickfixFormatter) End() error {
+	return nil
+}
+
+func (f *quickfixFormatter) Format(path string, results grep.Results) error {
+	buf := getBuf()
+	defer putBuf(buf)
+
+	if results == nil {
+		// ports matched only by negated searches have no results
+		writeQuickfix(buf, filepath.Join(path, "Makefile"), 1, 1, nil)
+	}
+
+	for _, m := range results {
+		if m.Unexpanded != nil {
+			// the match was found with variables expanded, point to the
+			// assignment it was expanded from
+			writeQuickfix(buf, filepath.Join(path, m.File), m.Line, 1, lineAt(m.Unexpanded, 0))
+			continue
+		}
+		idx := m.ResultSubmatch
+		if idx == nil {
+			idx = []int{0, 0}
+		}
+		for i := 0; i+1 < len(idx); i += 2 {
+			line, col := m.Position(idx[i])
+			writeQuickfix(buf, filepath.Join(path, m.File), line, col, lineAt(m.Text, idx[i]))
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.w.Write(buf.Bytes())
+	return err
+}
+
+func writeQuickfix(buf *bytes.Buffer, path string, line, col int, text []byte) {
+	buf.WriteString(path)
+	buf.WriteByte(':')
+	buf.WriteString(strconv.Itoa(line))
+	buf.WriteByte(':')
+	buf.WriteString(strconv.Itoa(col))
+	buf.WriteByte(':')
+	buf.Write(text)
+	buf.WriteByte('\n')
+}
+
+// lineAt returns the line of text containing text[i], without the trailing
+// newline.
+func lineAt(text []byte, i int) []byte {
+	s := bytes.LastIndexByte(text[:i], '\n') + 1
+	e := bytes.IndexByte(text[i:], '\n')
+	if e < 0 {
+		return text[s:]
+	}
+	return text[s : i+e]
+}
@@ -0,0 +1,51 @@
+package formatter
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+func TestQuickfix(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile": "PORTNAME=	foo\nLIB_DEPENDS=	libbar.so:devel/bar \\\n		libfoo.so:devel/foo\n",
+	})
+
+	rx, err := grep.Patterns.Get('l', "lib[a-z]+").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	f := NewQuickfix(&buf)
+	err = grep.Search(context.Background(), grep.Options{
+		PortsRoot: root,
+		Expr:      grep.Term(rx),
+		Flags:     grep.GallMatches,
+		Func: func(path string, results grep.Results, err error) error {
+			if err != nil {
+				return err
+			}
+			return f.Format(path, results)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ports matched only by negated searches have no results
+	if err := f.Format(filepath.Join(root, "devel/bar"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second match is on a continuation line
+	mk := filepath.Join(root, "devel/foo/Makefile")
+	exp := mk + ":2:14:LIB_DEPENDS=\tlibbar.so:devel/bar \\\n" +
+		mk + ":3:3:\t\tlibfoo.so:devel/foo\n" +
+		filepath.Join(root, "devel/bar/Makefile") + ":1:1:\n"
+	if buf.String() != exp {
+		t.Errorf("expected output\n%s\ngot\n%s", exp, buf.String())
+	}
+}
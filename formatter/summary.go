@@ -0,0 +1,194 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+// SummaryFunc returns keys a matching port is counted under in the summary,
+// origin is the port origin and results are its match results.
+type SummaryFunc func(origin string, results grep.Results) ([]string, error)
+
+// CategorySummary is SummaryFunc grouping ports by category.
+func CategorySummary(origin string, results grep.Results) ([]string, error) {
+	if i := strings.IndexByte(origin, '/'); i >= 0 {
+		origin = origin[:i]
+	}
+	return []string{origin}, nil
+}
+
+// ValueSummary is SummaryFunc grouping ports by result submatch values.  Ports
+// are counted once under each distinct value.
+func ValueSummary(origin string, results grep.Results) ([]string, error) {
+	var res []string
+	for _, m := range results {
+		res = append(res, resultValues(m)...)
+	}
+	sort.Strings(res)
+	return uniqueSorted(res), nil
+}
+
+type summaryFormatter struct {
+	mu sync.Mutex // protects w and counts
+	w  io.Writer
+
+	root   string
+	flags  int
+	group  string
+	key    SummaryFunc
+	limit  int
+	json   bool
+	next   Formatter
+	counts map[string]int
+
+	// document is set if results and the summary are written as members of
+	// a single JSON object
+	document bool
+}
+
+// NewSummary returns a formatter that counts matching ports grouped by keys
+// returned by key and writes the summary table, or JSON object if json is
+// true, on End.  Groups are ordered by the number of ports, and only the
+// first limit of them are written, unless limit is 0.  The group is the name
+// of the grouping written in the summary header.  If next is not nil, it's
+// called to format results too, and the summary is written after them.  If
+// next is a JSON formatter, results and the summary are written as a single
+// JSON object {"ports": [...], "summary": {...}}, and if next is a NDJSON
+// formatter, the summary is written as the last line {"summary": {...}}.
+func NewSummary(w io.Writer, root string, flags int, group string, key SummaryFunc, limit int, json bool, next Formatter) Formatter {
+	f := &summaryFormatter{
+		w:      w,
+		root:   root,
+		flags:  flags,
+		group:  group,
+		key:    key,
+		limit:  limit,
+		json:   json,
+		next:   next,
+		counts: make(map[string]int),
+	}
+	if !strings.HasSuffix(root, "/") {
+		f.root = f.root + "/"
+	}
+	if jf, ok := next.(*jsonFormatter); ok && json && !jf.lines {
+		jf.nested = true
+		f.document = true
+	}
+	return f
+}
+
+func (f *summaryFormatter) SetIndent(indent string) {
+	if f.next != nil {
+		f.next.SetIndent(indent)
+	}
+}
+
+func (f *summaryFormatter) Begin() error {
+	if f.document {
+		if _, err := io.WriteString(f.w, `{"ports":`); err != nil {
+			return err
+		}
+	}
+	if f.next != nil {
+		return f.next.Begin()
+	}
+	return nil
+}
+
+func (f *summaryFormatter) Format(path string, results grep.Results) error {
+	if f.next != nil {
+		if err := f.next.Format(path, results); err != nil {
+			return err
+		}
+	}
+
+	keys, err := f.key(strings.TrimPrefix(path, f.root), results)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, k := range keys {
+		f.counts[k]++
+	}
+	return nil
+}
+
+type jsonSummary struct {
+	Group  string              `json:"group"`
+	Counts []*jsonSummaryCount `json:"counts"`
+}
+
+type jsonSummaryCount struct {
+	Key   string `json:"key"`
+	Ports int    `json:"ports"`
+}
+
+func (f *summaryFormatter) End() error {
+	if f.next != nil {
+		if err := f.next.End(); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(f.counts))
+	for k := range f.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := f.counts[keys[i]], f.counts[keys[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return keys[i] < keys[j]
+	})
+	if f.limit > 0 && len(keys) > f.limit {
+		keys = keys[:f.limit]
+	}
+
+	if f.json {
+		s := &jsonSummary{Group: f.group, Counts: []*jsonSummaryCount{}}
+		for _, k := range keys {
+			s.Counts = append(s.Counts, &jsonSummaryCount{k, f.counts[k]})
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.document:
+			b = append(append([]byte(`,"summary":`), b...), '}')
+		case f.next != nil:
+			b = append(append([]byte(`{"summary":`), b...), '}')
+		}
+		_, err = f.w.Write(append(b, '\n'))
+		return err
+	}
+
+	buf := getBuf()
+	defer putBuf(buf)
+
+	width := len("ports")
+	for _, k := range keys {
+		if n := len(strconv.Itoa(f.counts[k])); n > width {
+			width = n
+		}
+	}
+	writeColor(buf, fmt.Sprintf("%*s  %s", width, "ports", f.group), cseparator, f.flags)
+	buf.WriteByte('\n')
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%*d  ", width, f.counts[k])
+		writeColor(buf, k, cmatch, f.flags)
+		buf.WriteByte('\n')
+	}
+	_, err := f.w.Write(buf.Bytes())
+	return err
+}
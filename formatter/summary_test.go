@@ -0,0 +1,102 @@
+package formatter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+func TestSummary(t *testing.T) {
+	uses := func(text string, rsm ...int) *grep.Result {
+		return &grep.Result{Text: []byte(text), ResultSubmatch: rsm, File: "Makefile"}
+	}
+	ports := []struct {
+		path    string
+		results grep.Results
+	}{
+		{"/ports/devel/foo", grep.Results{uses("USES=\tgo gmake\n", 6, 8, 9, 14)}},
+		// ports are counted once per distinct value
+		{"/ports/devel/bar", grep.Results{uses("USES=\tgo go\n", 6, 8, 9, 11)}},
+		{"/ports/lang/baz", grep.Results{uses("USES=\tgmake\n", 6, 11)}},
+		{"/ports/www/qux", grep.Results{uses("USES=\tcmake\n", 6, 11)}},
+	}
+	maintainers := map[string]string{
+		"devel/foo": "foo@example.org",
+		"devel/bar": "bar@example.org",
+		"lang/baz":  "foo@example.org",
+		"www/qux":   "ports@FreeBSD.org",
+	}
+	maintainer := func(origin string, results grep.Results) ([]string, error) {
+		return []string{maintainers[origin]}, nil
+	}
+
+	examples := []struct {
+		group  string
+		key    SummaryFunc
+		limit  int
+		json   bool
+		output string
+	}{
+		{"category", CategorySummary, 0, false, "ports  category\n    2  devel\n    1  lang\n    1  www\n"},
+		{"category", CategorySummary, 2, false, "ports  category\n    2  devel\n    1  lang\n"},
+		{"maintainer", maintainer, 0, false, "ports  maintainer\n    2  foo@example.org\n    1  bar@example.org\n    1  ports@FreeBSD.org\n"},
+		{"value", ValueSummary, 0, false, "ports  value\n    2  gmake\n    2  go\n    1  cmake\n"},
+		{"value", ValueSummary, 1, true, `{"group":"value","counts":[{"key":"gmake","ports":2}]}` + "\n"},
+		{"value", ValueSummary, 0, true, `{"group":"value","counts":[{"key":"gmake","ports":2},{"key":"go","ports":2},{"key":"cmake","ports":1}]}` + "\n"},
+	}
+
+	for i, x := range examples {
+		var buf bytes.Buffer
+		f := NewSummary(&buf, "/ports", FstripRoot, x.group, x.key, x.limit, x.json, nil)
+		if err := f.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ports {
+			if err := f.Format(p.path, p.results); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.End(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.output {
+			t.Errorf("[%d] expected output\n%s\ngot\n%s", i, x.output, buf.String())
+		}
+	}
+}
+
+func TestSummaryResults(t *testing.T) {
+	results := grep.Results{{Text: []byte("USES=\tgo\n"), ResultSubmatch: []int{6, 8}, File: "Makefile"}}
+	port := `{"origin":"devel/foo","path":"/ports/devel/foo","results":[{"file":"Makefile","text":"USES=\tgo\n","result_submatch":[6,8],"offset":0,"line":0,"column":0}]}`
+	summary := `{"group":"category","counts":[{"key":"devel","ports":1}]}`
+
+	examples := []struct {
+		next   func(*bytes.Buffer) Formatter
+		output string
+	}{
+		// results and summary are a single JSON document
+		{func(buf *bytes.Buffer) Formatter { return NewJSON(buf, "/ports", FstripRoot) },
+			`{"ports":[` + "\n" + port + "\n" + `],"summary":` + summary + "}\n"},
+		// summary is the last line
+		{func(buf *bytes.Buffer) Formatter { return NewNDJSON(buf, "/ports", FstripRoot) },
+			port + "\n" + `{"summary":` + summary + "}\n"},
+	}
+
+	for i, x := range examples {
+		var buf bytes.Buffer
+		f := NewSummary(&buf, "/ports", FstripRoot, "category", CategorySummary, 0, true, x.next(&buf))
+		if err := f.Begin(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Format("/ports/devel/foo", results); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.End(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.output {
+			t.Errorf("[%d] expected output\n%s\ngot\n%s", i, x.output, buf.String())
+		}
+	}
+}
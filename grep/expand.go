@@ -0,0 +1,63 @@
+package grep
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
+)
+
+// expandTexts parses variable assignments in Makefile texts and returns
+// texts holding assignments with variable references in them expanded.  Each
+// returned text has the same name as the Makefile it was derived from, and
+// each of its lines is mapped to the line the original assignment starts on.
+// Assignments in all texts are evaluated in order, as if the texts were
+// included one after another.
+func expandTexts(texts []*Text) []*Text {
+	vars := makefile.NewVars()
+
+	assignments := make([][]*makefile.Assignment, len(texts))
+	for i, t := range texts {
+		if !IsMakefile(t.Name) {
+			continue
+		}
+		assignments[i] = makefile.ParseAssignments(t.Restore(t.Bytes()))
+		for _, a := range assignments[i] {
+			vars.Apply(a)
+		}
+	}
+
+	var res []*Text
+	for i, t := range texts {
+		var b strings.Builder
+		x := &Text{Name: t.Name, source: t}
+
+		for _, a := range assignments[i] {
+			name, val := vars.Expand(a.Name), vars.Expand(a.Value)
+			if name == a.Name && val == a.Value {
+				continue // nothing to expand
+			}
+			x.starts = append(x.starts, b.Len())
+			x.lines = append(x.lines, a.Line)
+			b.WriteString(name)
+			b.WriteString(a.Op)
+			b.WriteByte('\t')
+			b.WriteString(val)
+			b.WriteByte('\n')
+		}
+
+		if x.starts != nil {
+			x.buf = []byte(b.String())
+			res = append(res, x)
+		}
+	}
+
+	return res
+}
+
+// IsMakefile reports whether the port file name is parsed as a Makefile, that
+// is, it's a Makefile, like "Makefile" or "Makefile.common", or a .mk file.
+func IsMakefile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, "Makefile") || strings.HasSuffix(base, ".mk")
+}
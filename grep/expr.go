@@ -0,0 +1,369 @@
+package grep
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Expr is a boolean search expression built of regular expression terms.
+type Expr interface {
+	// Eval evaluates the expression against port files texts.  A term
+	// matches if it matches in any of the texts.  Eval reports whether texts
+	// matched and returns results of all matching terms that are not negated.
+	// If all is true, terms report all their matches instead of only the
+	// first one.
+	Eval(texts []*Text, all bool) (bool, Results, error)
+}
+
+type termExpr struct {
+	rx   *Regexp
+	cond func(conds []string) bool // matches are filtered by conditions if set
+}
+
+// Term returns an expression that matches when rx matches.
+func Term(rx *Regexp) Expr {
+	return &termExpr{rx: rx}
+}
+
+func (e *termExpr) Eval(texts []*Text, all bool) (bool, Results, error) {
+	// assignments matched in texts as written, texts with expanded variables
+	// follow them and repeat these matches
+	type assignment struct {
+		file   string
+		offset int
+	}
+	var matched map[assignment]struct{}
+	for _, t := range texts {
+		if t.source != nil {
+			matched = make(map[assignment]struct{})
+			break
+		}
+	}
+
+	var res Results
+	for _, t := range texts {
+		n := 1
+		if all || e.cond != nil {
+			// the first match may be filtered out by its conditions
+			n = -1
+		}
+		rx := e.rx
+		if t.source != nil {
+			// neighbouring lines of expanded texts are unrelated
+			rx = rx.withoutContext()
+		}
+		ms, err := rx.matchText(t, n)
+		if err != nil {
+			return false, nil, err
+		}
+
+		for _, m := range ms {
+			m.File = t.Name
+			m.Text = t.Restore(m.Text)
+			m.setPosition(t)
+			if t.source != nil {
+				m.setUnexpanded(t.source)
+			}
+			if IsMakefile(t.Name) {
+				m.Conditions = t.conditions(m.Line)
+			}
+			if e.cond != nil && !e.cond(m.Conditions) {
+				continue
+			}
+
+			if matched != nil {
+				if m.Unexpanded != nil {
+					// Offset is that of the source assignment
+					if _, ok := matched[assignment{m.File, m.Offset}]; ok {
+						continue
+					}
+				} else if tok := t.assignmentAt(m.Line); t.source == nil && tok != nil {
+					matched[assignment{m.File, tok.Offset}] = struct{}{}
+				}
+			}
+			res = append(res, m)
+			if !all {
+				break
+			}
+		}
+
+		if res != nil && !all {
+			break // only the first match was requested
+		}
+	}
+	return res != nil, res, nil
+}
+
+// FilterConditions returns a copy of expr with each term matching only where
+// cond reports true for the conditional directives enclosing the match, see
+// Result.Conditions.
+func FilterConditions(expr Expr, cond func(conds []string) bool) Expr {
+	switch e := expr.(type) {
+	case *termExpr:
+		return &termExpr{rx: e.rx, cond: cond}
+	case andExpr:
+		c := make(andExpr, len(e))
+		for i, x := range e {
+			c[i] = FilterConditions(x, cond)
+		}
+		return c
+	case orExpr:
+		c := make(orExpr, len(e))
+		for i, x := range e {
+			c[i] = FilterConditions(x, cond)
+		}
+		return c
+	case *notExpr:
+		return &notExpr{FilterConditions(e.expr, cond)}
+	}
+	return expr
+}
+
+type andExpr []Expr
+
+// And returns an expression that matches when all of exprs match.
+func And(exprs ...Expr) Expr {
+	return andExpr(exprs)
+}
+
+func (e andExpr) Eval(texts []*Text, all bool) (bool, Results, error) {
+	var res Results
+	for _, x := range e {
+		ok, r, err := x.Eval(texts, all)
+		if err != nil || !ok {
+			return false, nil, err
+		}
+		res = append(res, r...)
+	}
+	return true, res, nil
+}
+
+type orExpr []Expr
+
+// Or returns an expression that matches when any of exprs match.  All
+// subexpressions are evaluated to collect their results.
+func Or(exprs ...Expr) Expr {
+	return orExpr(exprs)
+}
+
+func (e orExpr) Eval(texts []*Text, all bool) (bool, Results, error) {
+	var matched bool
+	var res Results
+	for _, x := range e {
+		ok, r, err := x.Eval(texts, all)
+		if err != nil {
+			return false, nil, err
+		}
+		if ok {
+			matched = true
+			res = append(res, r...)
+		}
+	}
+	return matched, res, nil
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+// Not returns an expression that matches when expr doesn't match.  It never
+// has any results.
+func Not(expr Expr) Expr {
+	return &notExpr{expr}
+}
+
+func (e *notExpr) Eval(texts []*Text, all bool) (bool, Results, error) {
+	ok, _, err := e.expr.Eval(texts, false)
+	if err != nil {
+		return false, nil, err
+	}
+	return !ok, nil, nil
+}
+
+// ParseExpr parses a boolean search expression.  Terms are predefined search
+// options with their queries, like "u:go" or "X", or quoted regular
+// expressions.  Terms can be negated with "!", combined with "&" (or just
+// juxtaposed) and "|", and grouped with parentheses.  "&" binds tighter than
+// "|".  Queries containing spaces or any of "()|&" must be quoted with single
+// or double quotes.  For example:
+//
+//	u:go & !X & (m:ports@ | m:me@)
+func (r Registry) ParseExpr(s string, ctxBefore, ctxAfter int, quote bool) (Expr, error) {
+	p := &exprParser{
+		reg:       r,
+		s:         s,
+		ctxBefore: ctxBefore,
+		ctxAfter:  ctxAfter,
+		quote:     quote,
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %s", s, err)
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("expression %q: unexpected %q at offset %d", s, p.s[p.pos], p.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	reg       Registry
+	s         string
+	pos       int
+	ctxBefore int
+	ctxAfter  int
+	quote     bool
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character or 0 at the end of input.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	var exprs []Expr
+	for {
+		e, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return Or(exprs...), nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	var exprs []Expr
+	for {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		c := p.peek()
+		if c == '&' {
+			p.pos++
+		} else if c == 0 || c == '|' || c == ')' {
+			break
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return And(exprs...), nil
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	switch c := p.peek(); c {
+	case '!':
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	case '(':
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		p.pos++
+		return e, nil
+	case '\'', '"':
+		q, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		rx, err := Compile(q, p.ctxBefore, p.ctxAfter, p.quote)
+		if err != nil {
+			return nil, err
+		}
+		return Term(rx), nil
+	case ')', '|', '&':
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	case 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return p.parseTerm()
+	}
+}
+
+func (p *exprParser) parseTerm() (Expr, error) {
+	start := p.pos
+	opt := p.s[p.pos]
+	p.pos++
+
+	var query string
+	hasQuery := p.pos < len(p.s) && p.s[p.pos] == ':'
+	if hasQuery {
+		p.pos++
+		if p.pos < len(p.s) && (p.s[p.pos] == '\'' || p.s[p.pos] == '"') {
+			q, err := p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+			query = q
+		} else {
+			i := strings.IndexFunc(p.s[p.pos:], func(r rune) bool {
+				return unicode.IsSpace(r) || strings.ContainsRune("()|&", r)
+			})
+			if i < 0 {
+				i = len(p.s) - p.pos
+			}
+			query = p.s[p.pos : p.pos+i]
+			p.pos += i
+		}
+	} else if p.pos < len(p.s) && !unicode.IsSpace(rune(p.s[p.pos])) && !strings.ContainsRune("()|&", rune(p.s[p.pos])) {
+		return nil, fmt.Errorf("invalid term at offset %d", start)
+	}
+
+	pt := p.reg.Get(opt, query)
+	if pt == nil {
+		return nil, fmt.Errorf("unknown search -%c at offset %d", opt, start)
+	}
+	if strings.HasSuffix(pt.optionString(), ":") {
+		if query == "" {
+			return nil, fmt.Errorf("search -%c requires a query at offset %d", opt, start)
+		}
+	} else if hasQuery {
+		return nil, fmt.Errorf("search -%c takes no query at offset %d", opt, start)
+	}
+	rx, err := pt.Compile(p.ctxBefore, p.ctxAfter, p.quote)
+	if err != nil {
+		return nil, fmt.Errorf("-%c: %s", opt, err)
+	}
+	return Term(rx), nil
+}
+
+func (p *exprParser) parseQuoted() (string, error) {
+	start := p.pos
+	q := p.s[p.pos]
+	i := strings.IndexByte(p.s[p.pos+1:], q)
+	if i < 0 {
+		return "", fmt.Errorf("unterminated quote at offset %d", start)
+	}
+	p.pos += i + 2
+	return p.s[start+1 : p.pos-1], nil
+}
@@ -0,0 +1,150 @@
+package grep
+
+import (
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	texts := []*Text{NewText("Makefile", []byte(`PORTNAME=	foo
+MAINTAINER=	ports@FreeBSD.org
+USES=		go:modules
+`))}
+
+	examples := []struct {
+		expr    string
+		matches bool
+		results int
+	}{
+		{"u:go", true, 1},
+		{"u:go & m:ports@", true, 2},
+		{"u:go m:ports@", true, 2},
+		{"u:go & !X", true, 1},
+		{"u:go & X", false, 0},
+		{"!u:go", false, 0},
+		{"!(u:cmake | X)", true, 0},
+		{"u:go & (m:me@ | m:ports@)", true, 2},
+		{"u:cmake | n:foo | m:ports@", true, 2},
+		{"'USES=\\s+go' & !n:bar", true, 1},
+		{`w:"PORTNAME=f.o"`, true, 1},
+	}
+
+	for i, x := range examples {
+		e, err := Patterns.ParseExpr(x.expr, 0, 0, false)
+		if err != nil {
+			t.Fatalf("[#%d] %s", i, err)
+		}
+		ok, res, err := e.Eval(texts, false)
+		if err != nil {
+			t.Fatalf("[#%d] %s", i, err)
+		}
+		if ok != x.matches {
+			t.Errorf("[#%d] expected %q match to be %v, got %v", i, x.expr, x.matches, ok)
+		}
+		if len(res) != x.results {
+			t.Errorf("[#%d] expected %q to have %d results, got %d", i, x.expr, x.results, len(res))
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	examples := []string{
+		"",
+		"u:go &",
+		"(u:go",
+		"u:go)",
+		"| u:go",
+		"u",
+		"Xu:go",
+		"'unterminated",
+		"u:",
+		"u:''",
+		"X:foo",
+		"X:",
+	}
+
+	for i, x := range examples {
+		if _, err := Patterns.ParseExpr(x, 0, 0, false); err == nil {
+			t.Errorf("[#%d] expected %q to fail", i, x)
+		}
+	}
+}
+
+func TestFilterConditions(t *testing.T) {
+	texts := []*Text{NewText("Makefile", []byte(`LIB_DEPENDS=	libfoo.so:devel/foo
+.if ${PORT_OPTIONS:MX11}
+LIB_DEPENDS+=	libfoo.so:devel/foo
+.endif
+`))}
+
+	rx, err := Patterns.Get('l', "libfoo").Compile(1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// context of both matches overlaps, but each is filtered by its own
+	// conditions
+	examples := []struct {
+		cond func(conds []string) bool
+		line int
+	}{
+		{func(conds []string) bool { return len(conds) == 0 }, 1},
+		{func(conds []string) bool { return len(conds) > 0 }, 3},
+	}
+	for i, x := range examples {
+		ok, res, err := FilterConditions(Term(rx), x.cond).Eval(texts, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = mergeResults(res)
+		if !ok || len(res) != 1 || len(res[0].ResultSubmatch) != 2 {
+			t.Fatalf("[%d] expected one result with one match, got %v", i, res)
+		}
+		if res[0].Line != x.line {
+			t.Errorf("[%d] expected match on line %d, got %d", i, x.line, res[0].Line)
+		}
+	}
+
+	// matches inside of different conditionals are not merged
+	ok, res, err := Term(rx).Eval(texts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res = mergeResults(res); !ok || len(res) != 2 {
+		t.Errorf("expected two results, got %v", res)
+	}
+}
+
+func TestFilterConditionsFirstMatch(t *testing.T) {
+	texts := []*Text{NewText("Makefile", []byte(`USES=	gmake
+.if ${PORT_OPTIONS:MX}
+USES+=	gmake
+.endif
+`))}
+
+	rx, err := Patterns.Get('u', "gmake").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first match is filtered out, a later one must still be found
+	// when only the first match is requested
+	cond := func(conds []string) bool { return len(conds) > 0 }
+	ok, res, err := FilterConditions(Term(rx), cond).Eval(texts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(res) != 1 {
+		t.Fatalf("expected one result, got %v", res)
+	}
+	if res[0].Line != 3 {
+		t.Errorf("expected match on line 3, got %d", res[0].Line)
+	}
+
+	ok, _, err = Not(FilterConditions(Term(rx), cond)).Eval(texts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected negated term not to match")
+	}
+}
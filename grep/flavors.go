@@ -0,0 +1,145 @@
+package grep
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
+)
+
+var (
+	flavorCmpRe   = regexp.MustCompile(`^\$\{FLAVOR(:U[^}]*)?\}\s*(==|!=)\s*"?([\w.-]*)"?$`)
+	flavorMatchRe = regexp.MustCompile(`^(\$\{FLAVOR:M([^}:]+)\}|empty\(FLAVOR:M([^):]+)\))$`)
+)
+
+// portFlavors returns flavors listed in FLAVORS of port Makefile texts, in
+// order.  Flavors set by variable references are skipped.
+func portFlavors(texts []*Text) []string {
+	var res []string
+	seen := make(map[string]struct{})
+	for _, t := range texts {
+		if t.source != nil || !IsMakefile(t.Name) {
+			continue
+		}
+		for _, tok := range t.Tokens() {
+			if tok.Type != makefile.Tassignment || tok.Name != "FLAVORS" {
+				continue
+			}
+			if tok.Op == "=" || tok.Op == ":=" {
+				res = res[:0]
+				seen = make(map[string]struct{})
+			}
+			for _, w := range tok.Words {
+				if _, ok := seen[w.Text]; ok || strings.Contains(w.Text, "$") {
+					continue
+				}
+				seen[w.Text] = struct{}{}
+				res = append(res, w.Text)
+			}
+		}
+	}
+	return res
+}
+
+// setFlavors sets PortFlavors and Flavors of results found in texts.  A match
+// is restricted to a flavor if it's in a flavor helper variable, like
+// "py39_RUN_DEPENDS", or inside of a conditional testing FLAVOR, like ".if
+// ${FLAVOR} == py39".
+func setFlavors(results Results, texts []*Text) {
+	var flavors []string
+	for _, m := range results {
+		t := sourceText(texts, m.File)
+		if t == nil || !IsMakefile(t.Name) {
+			continue
+		}
+		if flavors == nil {
+			if flavors = portFlavors(texts); len(flavors) == 0 {
+				return
+			}
+		}
+
+		m.PortFlavors = flavors
+		applies := flavors
+		if tok := t.assignmentAt(m.Line); tok != nil {
+			for _, f := range flavors {
+				if strings.HasPrefix(tok.Name, f+"_") {
+					applies = []string{f}
+					break
+				}
+			}
+		}
+		for _, c := range m.Conditions {
+			applies = filterFlavors(c, applies)
+		}
+		if len(applies) < len(flavors) {
+			m.Flavors = applies
+		}
+	}
+}
+
+// filterFlavors returns flavors for which conditional directive cond, as
+// returned by Text.conditions, holds.  Directives testing anything else than
+// FLAVOR leave flavors unchanged.
+func filterFlavors(cond string, flavors []string) []string {
+	negated := false
+	if strings.HasPrefix(cond, ".else (") {
+		cond = strings.TrimSuffix(strings.TrimPrefix(cond, ".else ("), ")")
+		negated = true
+	}
+	if !strings.HasPrefix(cond, ".if ") && !strings.HasPrefix(cond, ".elif ") {
+		return flavors
+	}
+	expr := cond[strings.IndexByte(cond, ' ')+1:]
+
+	// only simple tests or tests OR-ed with ||, like
+	// ${FLAVOR} == py39 || ${FLAVOR:Mpy31*}
+	var tests []func(string) bool
+	for _, s := range strings.Split(expr, "||") {
+		test := flavorTest(strings.TrimSpace(s))
+		if test == nil {
+			return flavors
+		}
+		tests = append(tests, test)
+	}
+
+	res := make([]string, 0, len(flavors))
+	for _, f := range flavors {
+		ok := false
+		for _, test := range tests {
+			ok = ok || test(f)
+		}
+		if ok != negated {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// flavorTest returns the function evaluating a single FLAVOR test s for a
+// flavor, or nil if s is not a FLAVOR test.
+func flavorTest(s string) func(string) bool {
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	not := strings.HasPrefix(s, "!")
+	s = strings.TrimSpace(strings.TrimPrefix(s, "!"))
+
+	if m := flavorCmpRe.FindStringSubmatch(s); m != nil {
+		eq := m[2] == "=="
+		return func(f string) bool {
+			return (f == m[3]) == eq != not
+		}
+	}
+	if m := flavorMatchRe.FindStringSubmatch(s); m != nil {
+		pat := m[2]
+		if m[3] != "" {
+			pat, not = m[3], !not
+		}
+		return func(f string) bool {
+			ok, _ := path.Match(pat, f)
+			return ok != not
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package grep
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterFlavors(t *testing.T) {
+	flavors := []string{"py39", "py310", "py311"}
+
+	examples := []struct {
+		cond string
+		res  []string
+	}{
+		{".if ${FLAVOR} == py39", []string{"py39"}},
+		{`.if ${FLAVOR:U} == "py310"`, []string{"py310"}},
+		{".if ${FLAVOR} != py39", []string{"py310", "py311"}},
+		{".elif ${FLAVOR:Mpy31*}", []string{"py310", "py311"}},
+		{".if !${FLAVOR:Mpy31*}", []string{"py39"}},
+		{".if empty(FLAVOR:Mpy39)", []string{"py310", "py311"}},
+		{".if ${FLAVOR} == py39 || ${FLAVOR} == py311", []string{"py39", "py311"}},
+		{".else (.if ${FLAVOR} == py39)", []string{"py310", "py311"}},
+		{".if ${FLAVOR} == py39 && ${ARCH} == i386", flavors},
+		{".if ${ARCH} == i386", flavors},
+		{".ifdef FLAVOR", flavors},
+	}
+
+	for i, x := range examples {
+		if res := filterFlavors(x.cond, flavors); !reflect.DeepEqual(res, x.res) {
+			t.Errorf("[%d] expected flavors of %q to be %v, got %v", i, x.cond, x.res, res)
+		}
+	}
+}
+
+func TestSetFlavors(t *testing.T) {
+	texts := []*Text{NewText("Makefile", []byte(`FLAVORS=	py39 py310
+RUN_DEPENDS=	foo>0:devel/foo
+py39_RUN_DEPENDS=	bar>0:devel/bar
+.if ${FLAVOR} == py310
+RUN_DEPENDS+=	baz>0:devel/baz
+.endif
+`))}
+
+	results := Results{
+		{File: "Makefile", Line: 2},
+		{File: "Makefile", Line: 3},
+		{File: "Makefile", Line: 5, Conditions: []string{".if ${FLAVOR} == py310"}},
+	}
+	setFlavors(results, texts)
+
+	for i, flavors := range [][]string{nil, {"py39"}, {"py310"}} {
+		if !reflect.DeepEqual(results[i].PortFlavors, []string{"py39", "py310"}) {
+			t.Errorf("[%d] expected port flavors [py39 py310], got %v", i, results[i].PortFlavors)
+		}
+		if !reflect.DeepEqual(results[i].Flavors, flavors) {
+			t.Errorf("[%d] expected flavors %v, got %v", i, flavors, results[i].Flavors)
+		}
+	}
+}
+
+func TestSearchFlavors(t *testing.T) {
+	rx, err := Patterns.Get('r', "foo").Compile(1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := searchMakefile(t, `FLAVORS=	py39 py310
+py39_RUN_DEPENDS=	foo>0:devel/foo
+py310_RUN_DEPENDS=	foo>0:devel/foo
+`, rx, GallMatches)
+
+	// context of both matches overlaps, but they apply to different flavors
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results, got %v", res)
+	}
+	for i, flavors := range [][]string{{"py39"}, {"py310"}} {
+		if !reflect.DeepEqual(res[i].Flavors, flavors) {
+			t.Errorf("[%d] expected flavors %v, got %v", i, flavors, res[i].Flavors)
+		}
+	}
+}
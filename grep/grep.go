@@ -2,13 +2,13 @@ package grep
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
-	"syscall"
 )
 
 // Stop is a special value that can be returned by GrepFunc to indicate that
@@ -19,42 +19,291 @@ var Stop = errors.New("stop")
 type Result struct {
 	// Text holds the match as a byte slice
 	Text []byte
-	// QuerySubmatch is a byte index pair identifying the query submatch in Text
+	// QuerySubmatch is a byte index pair identifying the query submatch in
+	// Text.  If context of several matches was merged into one Result, it
+	// holds one pair per match.
 	QuerySubmatch []int
-	// QuerySubmatch is a byte index pair identifying the result submatch in Text
+	// ResultSubmatch is a byte index pair identifying the result submatch in
+	// Text.  If context of several matches was merged into one Result, it
+	// holds one pair per match.
 	ResultSubmatch []int
+	// File is the name of the file the match was found in, relative to the
+	// port directory
+	File string
+	// Offset is the byte offset of Text in the searched file
+	Offset int
+	// Line and Column are the line and column numbers (starting from 1) of
+	// the first result submatch, or of the start of Text if there are no
+	// submatches
+	Line   int
+	Column int
+	// Conditions lists conditional directives enclosing the match, outermost
+	// first, like ".if ${ARCH} == i386".  Context of matches is merged into
+	// one Result only if they are inside of the same conditionals.
+	Conditions []string
+	// Unexpanded is set for matches found in variable assignments with
+	// references expanded, see GexpandVars.  It holds the assignment as
+	// written in File, while Text holds it expanded, with submatches indexing
+	// into Text.  Offset and Line are those of the assignment in File, Column
+	// is 0, since the match has no position in the file.
+	Unexpanded []byte
+	// Option is the port option pulling in the match, either by an options
+	// helper like "X11_LIB_DEPENDS" or by a conditional testing PORT_OPTIONS.
+	// It's prefixed with "!" if the match applies when the option is off.
+	// Context of matches pulled in by different options is not merged.
+	Option string
+	// PortFlavors lists FLAVORS of the port the match was found in.
+	PortFlavors []string
+	// Flavors lists port flavors the match is restricted to by a flavor
+	// helper like "py39_RUN_DEPENDS" or by a conditional testing FLAVOR, or
+	// is nil if the match applies to all of PortFlavors.  Context of matches
+	// restricted to different flavors is not merged.
+	Flavors []string
+
+	line int // line number of the start of Text
 }
 
 func (r *Result) String() string {
-	return fmt.Sprintf("Result {Text: %q, QuerySubmatch:%v, ResultSubmatch:%v}", string(r.Text), r.QuerySubmatch, r.ResultSubmatch)
+	return fmt.Sprintf("Result {Text: %q, QuerySubmatch:%v, ResultSubmatch:%v, Line:%d, Column:%d}", string(r.Text), r.QuerySubmatch, r.ResultSubmatch, r.Line, r.Column)
+}
+
+// Position returns the line and column numbers (starting from 1) of the byte
+// at index i of Text.
+func (r *Result) Position(i int) (line, col int) {
+	line = r.line + bytes.Count(r.Text[:i], []byte{'\n'})
+	col = i - bytes.LastIndexByte(r.Text[:i], '\n')
+	return line, col
+}
+
+// setPosition sets result line numbers, t is the text r was matched in.
+func (r *Result) setPosition(t *Text) {
+	r.line = t.Line(r.Offset)
+	i := 0
+	if r.ResultSubmatch != nil {
+		i = r.ResultSubmatch[0]
+	} else if r.QuerySubmatch != nil {
+		i = r.QuerySubmatch[0]
+	}
+	r.Line, r.Column = r.Position(i)
+}
+
+// setUnexpanded maps r, matched in a text derived from t by expanding
+// variables, back to the assignment in t it was expanded from.
+func (r *Result) setUnexpanded(t *Text) {
+	tok := t.assignmentAt(r.Line)
+	if tok == nil {
+		return
+	}
+	r.Unexpanded = t.Restore(t.Bytes()[tok.Offset:tok.End])
+	r.Offset = tok.Offset
+	r.Column = 0
+}
+
+// mergeResults merges consecutive results of matches in the same file with
+// overlapping context, or on the same line, into a single Result.  Context of
+// matches on adjacent lines only touches, so they are reported separately.
+// Matches found with variables expanded are merged only with matches in the
+// same assignment, and matches with different conditions, options or flavors
+// are never merged.
+func mergeResults(results Results) Results {
+	var res Results
+	for _, m := range results {
+		if n := len(res); n > 0 && res[n-1].overlaps(m) && res[n-1].sameScope(m) {
+			res[n-1].merge(m)
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// overlaps reports whether context of m overlaps with context of r.
+// Matches found with variables expanded overlap only if they are in the same
+// assignment.
+func (r *Result) overlaps(m *Result) bool {
+	if r.File != m.File {
+		return false
+	}
+	if r.Unexpanded != nil || m.Unexpanded != nil {
+		return r.Unexpanded != nil && m.Unexpanded != nil && r.Offset == m.Offset
+	}
+	return m.Offset >= r.Offset && m.Offset < r.Offset+len(r.Text)
+}
+
+// merge extends r with the context and submatches of m, following it.
+func (r *Result) merge(m *Result) {
+	shift := m.Offset - r.Offset
+	if n := len(r.Text) - shift; n < len(m.Text) {
+		// r.Text can be a slice of the searched text, don't append to it
+		// in place
+		r.Text = append(r.Text[:len(r.Text):len(r.Text)], m.Text[n:]...)
+	}
+	for _, i := range m.QuerySubmatch {
+		r.QuerySubmatch = append(r.QuerySubmatch, i+shift)
+	}
+	for _, i := range m.ResultSubmatch {
+		r.ResultSubmatch = append(r.ResultSubmatch, i+shift)
+	}
+}
+
+// sameScope reports whether r and m apply under the same conditions, options
+// and flavors.
+func (r *Result) sameScope(m *Result) bool {
+	return equalStrings(r.Conditions, m.Conditions) && r.Option == m.Option && equalStrings(r.Flavors, m.Flavors)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 type Results []*Result
 
 // GrepFunc is called for each found match and will be passed the path where
-// match was found and a slice of match results.  A Special error value Stop
-// can be returned to terminate search early.
+// match was found and a slice of match results.  If a port or a category
+// can't be searched, it's called with the error and the port or category
+// path, the search continues if GrepFunc returns nil.  A Special error value
+// Stop can be returned to terminate search early.
 type GrepFunc func(path string, res Results, err error) error
 
+// FileReader reads port files instead of reading them directly from disk, for
+// example from a cache.  ReadFile must be safe for concurrent use.
+type FileReader interface {
+	ReadFile(path string) ([]byte, error)
+}
+
+// DirReader is a FileReader that also lists categories and ports instead of
+// listing them directly from disk.  ReadDirs must be safe for concurrent use.
+type DirReader interface {
+	FileReader
+	// ReadDirs returns sorted names of subdirectories of the directory at
+	// path
+	ReadDirs(path string) ([]string, error)
+}
+
+// readDirs returns sorted names of subdirectories of the directory at path,
+// listed by fr if it's a DirReader.
+func readDirs(fr FileReader, path string) ([]string, error) {
+	if dr, ok := fr.(DirReader); ok {
+		return dr.ReadDirs(path)
+	}
+	return ReadDirs(path)
+}
+
+// ReadDirs returns sorted names of subdirectories of the directory at path.
+// Symlinks to directories are not included.
+func ReadDirs(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, e := range entries {
+		if e.IsDir() {
+			res = append(res, e.Name())
+		}
+	}
+	return res, nil
+}
+
+const (
+	// Gsorted makes results passed to GrepFunc sorted by port origin
+	Gsorted = 1 << iota
+	// GallMatches makes each regular expression report all its matches
+	// instead of only the first one
+	GallMatches
+	// Ginvert makes ports that do not match reported instead of ports that do
+	Ginvert
+	// GfollowIncludes makes files included by port Makefiles and files of
+	// master ports searched too
+	GfollowIncludes
+	// GexpandVars makes variable assignments searched with variable
+	// references in them expanded too.  Such matches are reported without
+	// context lines, see Result.Unexpanded
+	GexpandVars
+)
+
+// DefaultFiles is the list of port files searched by default.
+var DefaultFiles = []string{"Makefile"}
+
 // Grep searches port Makefiles, looking for matches described by rxs.  It
 // starts looking for Makefiles in root directory, and descends up to two
 // levels down (category/port).  If cats slice is not empty, Grep descends only
 // to categories listed in cats.  By default, multiple regular expressions in
 // rxs are AND-ed together, this can be changed by setting rxsOred to true.
 // The search will be run by using up to jobs goroutines, the usual practice is
-// to set this to runtime.NumCPU() for the best results.
+// to set this to runtime.NumCPU() for the best results.  Use Search for more
+// search options.
 func Grep(portsRoot string, categories []string, rxs []*Regexp, rxsOred bool, gfn GrepFunc, maxJobs int) error {
-	walkCh, err := walk(portsRoot, categories, maxJobs)
-	if err != nil {
-		return err
+	// no regular expressions, every port matches
+	var expr Expr
+	if len(rxs) > 0 {
+		terms := make([]Expr, 0, len(rxs))
+		for _, rx := range rxs {
+			terms = append(terms, Term(rx))
+		}
+		if rxsOred {
+			expr = Or(terms...)
+		} else {
+			expr = And(terms...)
+		}
 	}
-	grepCh, err := walkCh.grep(rxs, rxsOred, maxJobs)
+	return Search(context.Background(), Options{
+		PortsRoot:  portsRoot,
+		Categories: categories,
+		Expr:       expr,
+		Func:       gfn,
+		MaxJobs:    maxJobs,
+	})
+}
+
+// WalkFunc is called by Walk for each port and will be passed the port path
+// and texts of its files.  Like with GrepFunc, errors are passed with the port
+// or category path, and the walk continues if WalkFunc returns nil.
+type WalkFunc func(path string, texts []*Text, err error) error
+
+// Walk reads files of every port under portsRoot, like Search does, and
+// calls wfn for each port that has any of the files.  Only GfollowIncludes,
+// GexpandVars and Gsorted flags are used.  wfn can return Stop to terminate
+// the walk early.
+func Walk(portsRoot string, categories, files []string, fr FileReader, flags int, wfn WalkFunc, maxJobs int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	walkCh, err := walk(ctx, portsRoot, categories, fr, flags&Gsorted != 0, maxJobs)
 	if err != nil {
 		return err
 	}
+	textCh := walkCh.each(ctx, maxJobs, func(portRoot string, res *grepResult) {
+		texts, err := readTexts(portsRoot, portRoot, files, fr, flags)
+		if err != nil {
+			res.path, res.err = portRoot, err
+			return
+		}
+		if texts != nil {
+			res.path = portRoot
+			res.texts = texts
+		}
+	})
+
+	if flags&Gsorted != 0 {
+		textCh = textCh.reorder(ctx)
+	}
+	// stop the walk and wait for it to finish on return
+	defer textCh.drain(cancel)
 
-	for x := range grepCh {
-		if err := gfn(x.path, x.results, x.err); err != nil {
+	for x := range textCh {
+		if x.path == "" && x.err == nil {
+			continue // port has none of the files
+		}
+		if err := wfn(x.path, x.texts, x.err); err != nil {
 			if err == Stop {
 				break
 			}
@@ -65,6 +314,12 @@ func Grep(portsRoot string, categories []string, rxs []*Regexp, rxsOred bool, gf
 	return nil
 }
 
+// ReadPort reads texts of files of the port in portRoot, like Walk does.  It
+// returns nil texts if the port has none of the files.
+func ReadPort(portsRoot, portRoot string, files []string, fr FileReader, flags int) ([]*Text, error) {
+	return readTexts(portsRoot, portRoot, files, fr, flags)
+}
+
 var ignores = map[string]struct{}{
 	".git":      {},
 	".hooks":    {},
@@ -78,14 +333,17 @@ var ignores = map[string]struct{}{
 }
 
 type walkResult struct {
+	seq  int // sequence number, in port origin order when walk is sorted
 	path string
 	err  error
 }
 
 type walkChan chan walkResult
 
-func walk(portsRoot string, categories []string, maxJobs int) (walkChan, error) {
-	dir, err := ioutil.ReadDir(portsRoot)
+// walk sends paths of ports under portsRoot to the returned channel, until
+// all ports have been sent or ctx is cancelled.
+func walk(ctx context.Context, portsRoot string, categories []string, fr FileReader, sorted bool, maxJobs int) (walkChan, error) {
+	cats, err := readDirs(fr, portsRoot)
 	if err != nil {
 		return nil, err
 	}
@@ -101,15 +359,18 @@ func walk(portsRoot string, categories []string, maxJobs int) (walkChan, error)
 			catSet[c] = struct{}{}
 		}
 
+		// sorted walk lists categories one by one to number ports in
+		// origin order, this is cheap compared to grepping Makefiles
+		if sorted {
+			maxJobs = 1
+		}
+
 		var wg sync.WaitGroup
 		sem := make(chan int, maxJobs)
+		seq := 0
 
-		for _, fi := range dir {
-			if !fi.IsDir() {
-				continue
-			}
-
-			name := fi.Name()
+	loop:
+		for _, name := range cats {
 			if _, ok := ignores[name]; ok {
 				continue
 			}
@@ -120,7 +381,11 @@ func walk(portsRoot string, categories []string, maxJobs int) (walkChan, error)
 				}
 			}
 
-			sem <- 1
+			select {
+			case sem <- 1:
+			case <-ctx.Done():
+				break loop
+			}
 			wg.Add(1)
 
 			go func(cat string) {
@@ -130,14 +395,20 @@ func walk(portsRoot string, categories []string, maxJobs int) (walkChan, error)
 				}()
 
 				catRoot := filepath.Join(portsRoot, cat)
-				dir, err := ioutil.ReadDir(catRoot)
+				ports, err := readDirs(fr, catRoot)
 				if err != nil {
-					out <- walkResult{err: err}
+					out.send(ctx, walkResult{seq: seq, path: catRoot, err: err})
+					if sorted {
+						seq++
+					}
 					return
 				}
-				for _, fi := range dir {
-					if fi.IsDir() {
-						out <- walkResult{path: filepath.Join(catRoot, fi.Name())}
+				for _, name := range ports {
+					if !out.send(ctx, walkResult{seq: seq, path: filepath.Join(catRoot, name)}) {
+						return
+					}
+					if sorted {
+						seq++
 					}
 				}
 			}(name)
@@ -149,15 +420,33 @@ func walk(portsRoot string, categories []string, maxJobs int) (walkChan, error)
 	return out, nil
 }
 
+// send sends r to walk, it reports whether r was sent before ctx was
+// cancelled.
+func (walk walkChan) send(ctx context.Context, r walkResult) bool {
+	select {
+	case walk <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 type grepResult struct {
+	seq     int
 	path    string
 	results Results
+	texts   []*Text
 	err     error
 }
 
 type grepChan chan grepResult
 
-func (walk walkChan) grep(rxs []*Regexp, rxsOr bool, maxJobs int) (grepChan, error) {
+// each calls fn for every port received from walk, using up to maxJobs
+// goroutines, and sends results set by fn to the returned channel.  Every
+// port is reported, even if fn leaves its path empty, so that sorted results
+// can be reordered.  Once ctx is cancelled, remaining ports are skipped and
+// the returned channel is closed as soon as walk is.
+func (walk walkChan) each(ctx context.Context, maxJobs int, fn func(portRoot string, res *grepResult)) grepChan {
 	out := make(grepChan)
 
 	go func() {
@@ -168,64 +457,144 @@ func (walk walkChan) grep(rxs []*Regexp, rxsOr bool, maxJobs int) (grepChan, err
 
 		for w := range walk {
 			if w.err != nil {
-				out <- grepResult{err: w.err}
+				out.send(ctx, grepResult{seq: w.seq, path: w.path, err: w.err})
 				continue
 			}
 
-			// no regexp provided, everything matches
-			if len(rxs) == 0 {
-				out <- grepResult{path: w.path}
-				continue
+			select {
+			case sem <- 1:
+			case <-ctx.Done():
+				continue // drain walk
 			}
-
-			sem <- 1
 			wg.Add(1)
 
-			go func(portRoot string) {
+			go func(seq int, portRoot string) {
+				res := grepResult{seq: seq}
 				defer func() {
+					out.send(ctx, res)
 					<-sem
 					wg.Done()
 				}()
-
-				buf, err := readFile(filepath.Join(portRoot, "Makefile"))
-				if err != nil {
-					if err, ok := err.(*os.PathError); ok && err.Err == syscall.ENOENT {
-						// Makefile dosn't exist at path... odd, but okay
-						return
-					}
-					out <- grepResult{err: err}
-					return
+				if ctx.Err() == nil {
+					fn(portRoot, &res)
 				}
-				defer bufPut(buf)
+			}(w.seq, w.path)
+		}
 
-				b := bytes.ReplaceAll(buf.Bytes(), []byte{'\\', '\n'}, []byte{0, 0})
+		wg.Wait()
+	}()
 
-				var res Results
-				for _, r := range rxs {
-					m, err := r.Match(b)
-					if err != nil {
-						out <- grepResult{err: err}
-						return
-					}
-					if !rxsOr && m == nil {
-						return // results are ANDed and the current rx doesn't match
-					}
-					if m != nil {
-						m.Text = bytes.ReplaceAll(m.Text, []byte{0, 0}, []byte{'\\', '\n'})
-						res = append(res, m)
-					}
-				}
+	return out
+}
 
-				if res != nil {
-					out <- grepResult{path: portRoot, results: res}
-				}
-			}(w.path)
+// send sends r to grep, it reports whether r was sent before ctx was
+// cancelled.
+func (grep grepChan) send(ctx context.Context, r grepResult) bool {
+	select {
+	case grep <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// drain calls cancel to stop the pipeline sending to grep and waits for grep
+// to be closed, so that no pipeline goroutines are left running.
+func (grep grepChan) drain(cancel context.CancelFunc) {
+	cancel()
+	for range grep {
+	}
+}
+
+func (walk walkChan) grep(ctx context.Context, portsRoot string, files []string, fr FileReader, expr Expr, flags int, maxJobs int) (grepChan, error) {
+	return walk.each(ctx, maxJobs, func(portRoot string, res *grepResult) {
+		// no expression provided, everything matches
+		if expr == nil {
+			if flags&Ginvert == 0 {
+				res.path = portRoot
+			}
+			return
 		}
 
-		wg.Wait()
+		texts, err := readTexts(portsRoot, portRoot, files, fr, flags)
+		if err != nil {
+			res.path, res.err = portRoot, err
+			return
+		}
+		if texts == nil {
+			// none of the port files exist at path... odd, but okay, such
+			// port doesn't match
+			if flags&Ginvert != 0 {
+				res.path = portRoot
+			}
+			return
+		}
+
+		ok, results, err := expr.Eval(texts, flags&GallMatches != 0)
+		if err != nil {
+			res.path, res.err = portRoot, err
+			return
+		}
+		if flags&Ginvert != 0 {
+			if !ok {
+				res.path = portRoot
+			}
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if len(texts) > 1 {
+			sortByFile(results, texts)
+		}
+		setOptions(results, texts)
+		setFlavors(results, texts)
+		res.path = portRoot
+		res.results = mergeResults(results)
+	}), nil
+}
+
+// reorder returns a channel that passes grep results through in the sequence
+// number order.  Out of order results are buffered until all results
+// preceding them have been received.  Once ctx is cancelled, results are
+// dropped until grep is closed.
+func (grep grepChan) reorder(ctx context.Context) grepChan {
+	out := make(grepChan)
+
+	go func() {
+		defer close(out)
+
+		pending := make(map[int]grepResult)
+		next := 0
+
+		for x := range grep {
+			pending[x.seq] = x
+			for {
+				y, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				out.send(ctx, y)
+			}
+		}
 	}()
 
-	return out, nil
+	return out
+}
+
+// sortByFile sorts results in the order of texts they were found in,
+// preserving the order of results found in the same text.
+func sortByFile(results Results, texts []*Text) {
+	order := make(map[string]int, len(texts))
+	for i, t := range texts {
+		order[t.Name] = i
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return order[results[i].File] < order[results[j].File]
+	})
 }
 
 func readFile(filename string) (*bytes.Buffer, error) {
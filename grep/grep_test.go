@@ -0,0 +1,381 @@
+package grep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader fails to read files of the port in dir.
+type failingReader struct {
+	dir string
+}
+
+func (r failingReader) ReadFile(path string) ([]byte, error) {
+	if filepath.Dir(path) == r.dir {
+		return nil, errors.New("read error")
+	}
+	return os.ReadFile(path)
+}
+
+// searchMakefile searches a single port with Makefile contents mk and returns
+// its results.
+func searchMakefile(t *testing.T, mk string, rx *Regexp, flags int) Results {
+	root := writePorts(t, map[string]string{"devel/foo/Makefile": mk})
+
+	var res Results
+	err := Search(context.Background(), Options{
+		PortsRoot: root,
+		Expr:      Term(rx),
+		Flags:     flags,
+		Func: func(path string, results Results, err error) error {
+			res = append(res, results...)
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestGrepErrors(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile": "USES=	go\n",
+		"devel/bar/Makefile": "USES=	go\n",
+		"devel/baz/Makefile": "USES=	go\n",
+	})
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var matched, failed []string
+	gfn := func(path string, res Results, err error) error {
+		if err != nil {
+			failed = append(failed, path)
+			return nil
+		}
+		matched = append(matched, path)
+		return nil
+	}
+	err = Search(context.Background(), Options{
+		PortsRoot:  root,
+		FileReader: failingReader{filepath.Join(root, "devel/baz")},
+		Expr:       Term(rx),
+		Flags:      Gsorted,
+		Func:       gfn,
+		MaxJobs:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matched) != 2 || matched[0] != filepath.Join(root, "devel/bar") || matched[1] != filepath.Join(root, "devel/foo") {
+		t.Errorf("expected devel/bar and devel/foo to match, got %v", matched)
+	}
+	if len(failed) != 1 || failed[0] != filepath.Join(root, "devel/baz") {
+		t.Errorf("expected devel/baz to fail, got %v", failed)
+	}
+}
+
+// slowReader delays reading files of ports, the earlier the port in origin
+// order, the longer the delay.
+type slowReader struct {
+	delays map[string]time.Duration
+}
+
+func (r slowReader) ReadFile(path string) ([]byte, error) {
+	time.Sleep(r.delays[filepath.Base(filepath.Dir(path))])
+	return os.ReadFile(path)
+}
+
+func TestSearchSorted(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	files := make(map[string]string)
+	fr := slowReader{make(map[string]time.Duration)}
+	for i, name := range names {
+		files["devel/"+name+"/Makefile"] = "USES=	go\n"
+		fr.delays[name] = time.Duration(len(names)-i) * 10 * time.Millisecond
+	}
+	root := writePorts(t, files)
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ports finish in reverse order, but are reported sorted
+	var matched []string
+	err = Search(context.Background(), Options{
+		PortsRoot:  root,
+		FileReader: fr,
+		Expr:       Term(rx),
+		Flags:      Gsorted,
+		Func: func(path string, res Results, err error) error {
+			matched = append(matched, filepath.Base(path))
+			return err
+		},
+		MaxJobs: len(names),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(matched, "") != strings.Join(names, "") {
+		t.Errorf("expected ports in origin order %v, got %v", names, matched)
+	}
+}
+
+func TestGrepNoRegexps(t *testing.T) {
+	// every port matches, even one without a Makefile
+	root := writePorts(t, map[string]string{
+		"devel/bar/":         "",
+		"devel/foo/Makefile": "USES=	go\n",
+	})
+
+	for _, ored := range []bool{false, true} {
+		var matched []string
+		gfn := func(path string, res Results, err error) error {
+			if err != nil {
+				return err
+			}
+			matched = append(matched, path)
+			return nil
+		}
+		if err := Grep(root, nil, nil, ored, gfn, 1); err != nil {
+			t.Fatal(err)
+		}
+		if len(matched) != 2 {
+			t.Errorf("rxsOred=%t: expected every port to match, got %v", ored, matched)
+		}
+	}
+}
+
+func TestSearchStop(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("cat%d/port%d/Makefile", i%10, i)] = "USES=	go\n"
+	}
+	root := writePorts(t, files)
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []struct {
+		flags int
+		stop  func(cancel context.CancelFunc) error
+		err   error
+	}{
+		{0, func(context.CancelFunc) error { return Stop }, nil},
+		{Gsorted, func(context.CancelFunc) error { return Stop }, nil},
+		{0, func(context.CancelFunc) error { return errStop }, errStop},
+		{Gsorted, func(cancel context.CancelFunc) error { cancel(); return nil }, context.Canceled},
+	}
+
+	before := runtime.NumGoroutine()
+	for i, x := range examples {
+		ctx, cancel := context.WithCancel(context.Background())
+		n := 0
+		err := Search(ctx, Options{
+			PortsRoot: root,
+			Expr:      Term(rx),
+			Flags:     x.flags,
+			Func: func(path string, res Results, err error) error {
+				if n++; n == 10 {
+					return x.stop(cancel)
+				}
+				return nil
+			},
+			MaxJobs: 4,
+		})
+		cancel()
+		if err != x.err {
+			t.Errorf("[%d] expected error %v, got %v", i, x.err, err)
+		}
+		if n != 10 {
+			t.Errorf("[%d] expected search to stop after 10 ports, got %d", i, n)
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no goroutines left running, got %d more", after-before)
+	}
+
+	if err := Search(context.Background(), Options{PortsRoot: root, Expr: Term(rx)}); err == nil {
+		t.Error("expected error for nil Func")
+	}
+}
+
+var errStop = errors.New("stop search")
+
+func TestSearchFiles(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile":             "PORTNAME=	foo\n",
+		"devel/foo/pkg-plist":            "bin/foo\nshare/foo/README\n",
+		"devel/foo/files/patch-src_foo":  "--- src/foo.c.orig\n",
+		"devel/foo/files/patch-Makefile": "+USES=	foo\n",
+		"devel/foo/files/foo.in":         "foo\n",
+		"devel/bar/Makefile":             "PORTNAME=	bar\n",
+	})
+
+	rx, err := Compile("foo", 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res Results
+	err = Search(context.Background(), Options{
+		PortsRoot: root,
+		Files:     []string{"Makefile", "pkg-plist", "files/patch-*"},
+		Expr:      Term(rx),
+		Flags:     GallMatches,
+		Func: func(path string, results Results, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != filepath.Join(root, "devel/foo") {
+				t.Errorf("expected only devel/foo to match, got %s", path)
+			}
+			res = append(res, results...)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// results are in Files order, glob matches in lexical order
+	expected := []string{
+		"Makefile:1",
+		"pkg-plist:1",
+		"pkg-plist:2",
+		"files/patch-Makefile:1",
+		"files/patch-src_foo:1",
+	}
+	var got []string
+	for _, m := range res {
+		got = append(got, fmt.Sprintf("%s:%d", m.File, m.Line))
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected results %v, got %v", expected, got)
+	}
+}
+
+func TestSearchInvert(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile":  "USES=	go\n",
+		"devel/foo/pkg-plist": "bin/go\n",
+		"devel/bar/Makefile":  "USES=	cmake\n",
+		"devel/bar/pkg-plist": "bin/bar\n",
+		"www/baz/Makefile":    "USES=	go\n",
+		"www/qux/":            "",
+	})
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plistRx, err := Compile("go", 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	examples := []struct {
+		files    []string
+		expr     Expr
+		expected []string
+	}{
+		{nil, Term(rx), []string{"devel/bar", "www/qux"}},
+		// ports without pkg-plist don't match
+		{[]string{"pkg-plist"}, Term(plistRx), []string{"devel/bar", "www/baz", "www/qux"}},
+		// every port matches without an expression
+		{nil, nil, nil},
+	}
+	for i, x := range examples {
+		var matched []string
+		err := Search(context.Background(), Options{
+			PortsRoot: root,
+			Files:     x.files,
+			Expr:      x.expr,
+			Flags:     Ginvert | Gsorted,
+			Func: func(path string, res Results, err error) error {
+				if err != nil {
+					return err
+				}
+				if res != nil {
+					t.Errorf("[%d] expected no results for %s, got %v", i, path, res)
+				}
+				rel, _ := filepath.Rel(root, path)
+				matched = append(matched, rel)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(matched, " ") != strings.Join(x.expected, " ") {
+			t.Errorf("[%d] expected %v, got %v", i, x.expected, matched)
+		}
+	}
+}
+
+func TestExpandedResults(t *testing.T) {
+	src := "PORTNAME=	foo\n\nLIB_DEPENDS=	lib${PORTNAME}.so:devel/foo\nUSES=	go\n"
+	texts := []*Text{NewText("Makefile", []byte(src))}
+	texts = append(texts, expandTexts(texts)...)
+
+	rx, err := Patterns.Get('l', "libfoo").Compile(2, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, res, err := Term(rx).Eval(texts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(res) != 1 {
+		t.Fatalf("expected one result, got %v", res)
+	}
+
+	m := res[0]
+	if exp := "LIB_DEPENDS=\tlibfoo.so:devel/foo\n"; string(m.Text) != exp {
+		t.Errorf("expected text %q without context, got %q", exp, m.Text)
+	}
+	if exp := "LIB_DEPENDS=\tlib${PORTNAME}.so:devel/foo"; string(m.Unexpanded) != exp {
+		t.Errorf("expected unexpanded text %q, got %q", exp, m.Unexpanded)
+	}
+	if m.Offset != strings.Index(src, "LIB_DEPENDS") || m.Line != 3 || m.Column != 0 {
+		t.Errorf("expected offset %d, line 3 and column 0, got %d, %d and %d", strings.Index(src, "LIB_DEPENDS"), m.Offset, m.Line, m.Column)
+	}
+
+	// assignments matching as written are not reported again expanded, and
+	// all matches in an expanded assignment are merged
+	src = "A=	go\nB=	gmake\nUSES=	${A} \\\n	gmake\nUSES+=	${A} ${B}\n"
+	texts = []*Text{NewText("Makefile", []byte(src))}
+	texts = append(texts, expandTexts(texts)...)
+
+	rx, err = Patterns.Get('u', "g.*").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, res, err = Term(rx).Eval(texts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res = mergeResults(res); !ok || len(res) != 2 {
+		t.Fatalf("expected two results, got %v", res)
+	}
+	if res[0].Unexpanded != nil || res[0].Line != 4 {
+		t.Errorf("expected USES result on line 4 as written, got %v", res[0])
+	}
+	if res[1].Unexpanded == nil || res[1].Line != 5 || len(res[1].ResultSubmatch) != 4 {
+		t.Errorf("expected USES+ result on line 5 with two expanded matches, got %v", res[1])
+	}
+}
@@ -0,0 +1,230 @@
+package grep
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+var (
+	includeRe   = regexp.MustCompile(`(?m)^\.\s*[-sd]?include\s+"([^"]+)"`)
+	masterDirRe = regexp.MustCompile(`(?m)^MASTERDIR\s*[?:]?=\s*(\S+)`)
+	varRefRe    = regexp.MustCompile(`\$\{([^}]+)\}`)
+)
+
+// textReader reads port files, optionally following .include directives and
+// MASTERDIR of slave ports.
+type textReader struct {
+	portsRoot string
+	portRoot  string
+	fr        FileReader
+	follow    bool
+
+	masterDir string
+	seen      map[string]struct{}
+	texts     []*Text
+}
+
+// readTexts reads port files matching names, which can be glob patterns,
+// relative to portRoot, using fr if it's not nil.  Missing files are
+// ignored.  If GfollowIncludes flag is set, files missing in a slave port are
+// read from its MASTERDIR, and files included by .include directives are read
+// too, as long as they are inside the ports tree and outside of the ports
+// framework (Mk).  If GexpandVars flag is set, texts with expanded variable
+// assignments are appended to the returned texts.
+func readTexts(portsRoot, portRoot string, names []string, fr FileReader, flags int) ([]*Text, error) {
+	follow := flags&GfollowIncludes != 0
+
+	r := &textReader{
+		portsRoot: portsRoot,
+		portRoot:  portRoot,
+		fr:        fr,
+		follow:    follow,
+		seen:      make(map[string]struct{}),
+	}
+
+	if follow {
+		if err := r.resolveMasterDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, n := range names {
+		found, err := r.readGlob(portRoot, n)
+		if err != nil {
+			return nil, err
+		}
+		if !found && r.masterDir != "" && r.masterDir != portRoot {
+			if _, err := r.readGlob(r.masterDir, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if follow {
+		// r.texts grows as included files are read
+		for i := 0; i < len(r.texts); i++ {
+			if err := r.readIncludes(r.texts[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if flags&GexpandVars != 0 {
+		r.texts = append(r.texts, expandTexts(r.texts)...)
+	}
+
+	return r.texts, nil
+}
+
+// resolveMasterDir sets r.masterDir to MASTERDIR of the port, or to the port
+// directory if the port doesn't set it.
+func (r *textReader) resolveMasterDir() error {
+	r.masterDir = r.portRoot
+
+	data, done, err := r.readFile(filepath.Join(r.portRoot, "Makefile"))
+	if err != nil {
+		if isNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer done()
+
+	if m := masterDirRe.FindSubmatch(data); m != nil {
+		if dir, ok := r.expand(string(m[1]), r.portRoot); ok && r.insideTree(dir) {
+			r.masterDir = dir
+		}
+	}
+	return nil
+}
+
+// readGlob reads files in dir matching the glob pattern name.  It reports
+// whether any files were found.
+func (r *textReader) readGlob(dir, name string) (bool, error) {
+	paths := []string{filepath.Join(dir, name)}
+	if strings.ContainsAny(name, "*?[") {
+		var err error
+		paths, err = filepath.Glob(paths[0])
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var found bool
+	for _, p := range paths {
+		ok, err := r.read(p)
+		if err != nil {
+			return false, err
+		}
+		found = found || ok
+	}
+	return found, nil
+}
+
+// read reads the file at path, unless it was already read.  It reports
+// whether the file exists.
+func (r *textReader) read(path string) (bool, error) {
+	if _, ok := r.seen[path]; ok {
+		return true, nil
+	}
+
+	data, done, err := r.readFile(path)
+	if err != nil {
+		if isNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer done()
+
+	name, err := filepath.Rel(r.portRoot, path)
+	if err != nil {
+		name = path
+	}
+	r.seen[path] = struct{}{}
+	r.texts = append(r.texts, NewText(name, data))
+	return true, nil
+}
+
+// readFile returns contents of the file at path, read with r.fr if it's set.
+// done must be called once the returned data is no longer used.
+func (r *textReader) readFile(path string) (data []byte, done func(), err error) {
+	if r.fr != nil {
+		data, err := r.fr.ReadFile(path)
+		return data, func() {}, err
+	}
+
+	buf, err := readFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), func() { bufPut(buf) }, nil
+}
+
+func (r *textReader) readIncludes(t *Text) error {
+	parseDir := filepath.Dir(filepath.Join(r.portRoot, t.Name))
+
+	for _, m := range includeRe.FindAllSubmatch(t.Bytes(), -1) {
+		path, ok := r.expand(string(m[1]), parseDir)
+		if !ok || !r.insideTree(path) {
+			continue
+		}
+		if _, err := r.read(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand expands variable references in the include path s, parseDir is the
+// directory of the file s was found in.  It reports false if s references
+// unknown variables.
+func (r *textReader) expand(s, parseDir string) (string, bool) {
+	vars := map[string]string{
+		".CURDIR":   r.portRoot,
+		".PARSEDIR": parseDir,
+		"MASTERDIR": r.masterDir,
+		"PORTSDIR":  r.portsRoot,
+	}
+
+	// paths starting with a variable reference are not relative to parseDir,
+	// even if the variable expands to a relative path
+	relative := !filepath.IsAbs(s) && !strings.HasPrefix(s, "${")
+
+	ok := true
+	s = varRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		v, found := vars[ref[2:len(ref)-1]]
+		if !found {
+			ok = false
+		}
+		return v
+	})
+	if !ok {
+		return "", false
+	}
+
+	if relative {
+		s = filepath.Join(parseDir, s)
+	}
+	return filepath.Clean(s), true
+}
+
+// insideTree reports whether path is inside the ports tree and outside of
+// the ports framework.
+func (r *textReader) insideTree(path string) bool {
+	rel, err := filepath.Rel(r.portsRoot, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return !strings.HasPrefix(rel, "Mk"+string(filepath.Separator))
+}
+
+func isNotExist(err error) bool {
+	if err, ok := err.(*os.PathError); ok {
+		return err.Err == syscall.ENOENT || err.Err == syscall.EISDIR
+	}
+	return false
+}
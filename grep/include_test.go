@@ -0,0 +1,31 @@
+package grep
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadTextsFollow(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile":        "PORTNAME=	foo\n.include \"${.PARSEDIR}/Makefile.common\"\n.include <bsd.port.mk>\n",
+		"devel/foo/Makefile.common": ".include \"${PORTSDIR}/Mk/Uses/foo.mk\"\nUSES=	go\n",
+		"devel/foo/pkg-plist":       "bin/foo\n",
+		"devel/foo-nox11/Makefile":  "MASTERDIR=	${.CURDIR}/../foo\n.include \"${MASTERDIR}/Makefile\"\n",
+		"Mk/Uses/foo.mk":            "USES+=	bar\n",
+	})
+
+	texts, err := readTexts(root, filepath.Join(root, "devel/foo-nox11"), []string{"Makefile", "pkg-plist"}, nil, GfollowIncludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, t := range texts {
+		names = append(names, t.Name)
+	}
+	expected := []string{"Makefile", "../foo/pkg-plist", "../foo/Makefile", "../foo/Makefile.common"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
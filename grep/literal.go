@@ -0,0 +1,84 @@
+package grep
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// requiredLiterals returns literal strings that must be present in any text
+// matched by all of rxs, longest first.  They are used to quickly skip texts that
+// can't possibly match before running the regular expression.  Literals
+// shorter than 2 bytes aren't worth checking and are not returned.
+func requiredLiterals(rxs ...*regexp.Regexp) [][]byte {
+	var lits []string
+	for _, re := range rxs {
+		sre, err := syntax.Parse(re.String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+		lits = append(lits, literals(sre)...)
+	}
+
+	seen := make(map[string]struct{})
+	var res [][]byte
+	for _, s := range lits {
+		if _, ok := seen[s]; ok || len(s) < 2 {
+			continue
+		}
+		seen[s] = struct{}{}
+		res = append(res, []byte(s))
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return len(res[i]) > len(res[j])
+	})
+
+	return res
+}
+
+// literals returns case-sensitive literal strings required by re.
+func literals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return []string{string(re.Rune)}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return literals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var res []string
+		var cur strings.Builder // adjacent literals are joined
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+				cur.WriteString(string(sub.Rune))
+				continue
+			}
+			if cur.Len() > 0 {
+				res = append(res, cur.String())
+				cur.Reset()
+			}
+			res = append(res, literals(sub)...)
+		}
+		if cur.Len() > 0 {
+			res = append(res, cur.String())
+		}
+		return res
+	}
+	return nil
+}
+
+// mayMatch reports whether text contains all literals required by r.
+func (r *Regexp) mayMatch(text []byte) bool {
+	for _, l := range r.lits {
+		if !bytes.Contains(text, l) {
+			return false
+		}
+	}
+	return true
+}
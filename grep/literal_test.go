@@ -0,0 +1,91 @@
+package grep
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	examples := []struct {
+		pat string
+		exp []string
+	}{
+		{`foo`, []string{"foo"}},
+		{`foo.*bar`, []string{"foo", "bar"}},
+		{`(?P<q>USES)\s*=\s*(?P<r>go)`, []string{"USES", "go"}},
+		{`(?P<q>LIB_DEPENDS)=(?P<r>libfoo\.so)`, []string{"LIB_DEPENDS", "libfoo.so"}},
+		{`(foo|bar)baz`, []string{"baz"}},
+		{`(foo)?bar+`, []string{"ba"}},
+		{`(?i)foo`, nil},
+		{`x{2,}`, nil},
+		{`(abc){2}`, []string{"abc"}},
+	}
+
+	for i, x := range examples {
+		var lits []string
+		for _, l := range requiredLiterals(regexp.MustCompile(x.pat)) {
+			lits = append(lits, string(l))
+		}
+		if !reflect.DeepEqual(lits, x.exp) {
+			t.Errorf("[#%d] expected %q to require %q, got %q", i, x.pat, x.exp, lits)
+		}
+	}
+}
+
+// BenchmarkGrep searches a synthetic ports tree where only a few ports match,
+// with and without the literal prefilter.
+func BenchmarkGrep(b *testing.B) {
+	files := make(map[string]string)
+	for i := 0; i < 2000; i++ {
+		uses := "cmake pkgconfig"
+		if i%100 == 0 {
+			uses = "go:modules"
+		}
+		mk := fmt.Sprintf(`PORTNAME=	port%d
+DISTVERSION=	1.%d
+CATEGORIES=	devel
+
+MAINTAINER=	ports@FreeBSD.org
+COMMENT=	Synthetic port %d
+
+LICENSE=	BSD2CLAUSE
+
+LIB_DEPENDS=	libfoo.so:devel/foo \
+		libbar.so:devel/bar
+RUN_DEPENDS=	baz>0:devel/baz
+
+USES=		%s
+
+PLIST_FILES=	bin/port%d
+
+.include <bsd.port.mk>
+`, i, i, i, uses, i)
+		files[fmt.Sprintf("cat%d/port%d/Makefile", i%20, i)] = mk
+	}
+	root := writePorts(b, files)
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	gfn := func(path string, res Results, err error) error {
+		return err
+	}
+
+	bench := func(rx *Regexp) func(b *testing.B) {
+		return func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := Grep(root, nil, []*Regexp{rx}, false, gfn, 4); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	b.Run("prefilter", bench(rx))
+	norx := *rx
+	norx.lits = nil
+	b.Run("noprefilter", bench(&norx))
+}
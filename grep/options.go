@@ -0,0 +1,112 @@
+package grep
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
+)
+
+var (
+	optionsVarRe  = regexp.MustCompile(`^OPTIONS_(DEFINE(_\w+)?|(GROUP|SINGLE|RADIO|MULTI)_\w+)$`)
+	optionsCondRe = regexp.MustCompile(`(!?)\s*(\$\{|empty\()PORT_OPTIONS:M([\w+-]+)`)
+)
+
+// portOptions returns options defined by port Makefile texts in
+// OPTIONS_DEFINE and OPTIONS_GROUP, OPTIONS_SINGLE, OPTIONS_RADIO and
+// OPTIONS_MULTI groups.
+func portOptions(texts []*Text) map[string]struct{} {
+	res := make(map[string]struct{})
+	for _, t := range texts {
+		if t.source != nil || !IsMakefile(t.Name) {
+			continue
+		}
+		for _, tok := range t.Tokens() {
+			if tok.Type != makefile.Tassignment || !optionsVarRe.MatchString(tok.Name) {
+				continue
+			}
+			for _, w := range tok.Words {
+				res[w.Text] = struct{}{}
+			}
+		}
+	}
+	return res
+}
+
+// setOptions sets Option of results found in texts.  A match is pulled in by
+// an option if it's in an options helper variable, like "X11_LIB_DEPENDS", or
+// inside of a conditional testing PORT_OPTIONS, like ".if
+// ${PORT_OPTIONS:MX11}".
+func setOptions(results Results, texts []*Text) {
+	var opts map[string]struct{}
+
+	for _, m := range results {
+		t := sourceText(texts, m.File)
+		if t == nil || !IsMakefile(t.Name) {
+			continue
+		}
+		if opts == nil {
+			opts = portOptions(texts)
+		}
+
+		if tok := t.assignmentAt(m.Line); tok != nil {
+			if opt := helperOption(tok.Name, opts); opt != "" {
+				m.Option = opt
+				continue
+			}
+		}
+		m.Option = conditionOption(m.Conditions)
+	}
+}
+
+// sourceText returns the text read from the file name, ignoring texts with
+// expanded variables.
+func sourceText(texts []*Text, name string) *Text {
+	for _, t := range texts {
+		if t.Name == name && t.source == nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// helperOption returns the option of options helper variable name, prefixed
+// with "!" for helpers applied when the option is off, or "" if name is not a
+// helper of any of opts.
+func helperOption(name string, opts map[string]struct{}) string {
+	var res string
+	for o := range opts {
+		if strings.HasPrefix(name, o+"_") && len(o) > len(res) {
+			res = o
+		}
+	}
+	if res != "" && strings.HasSuffix(name, "_OFF") {
+		res = "!" + res
+	}
+	return res
+}
+
+// conditionOption returns the option tested by the innermost of conds,
+// prefixed with "!" if the conditional branch applies when the option is off,
+// or "" if none of conds test options.
+func conditionOption(conds []string) string {
+	for i := len(conds) - 1; i >= 0; i-- {
+		c := conds[i]
+		negated := false
+		if strings.HasPrefix(c, ".else (") {
+			negated = true
+		}
+		m := optionsCondRe.FindStringSubmatch(c)
+		if m == nil {
+			continue
+		}
+		if (m[1] == "!") != (m[2] == "empty(") {
+			negated = !negated
+		}
+		if negated {
+			return "!" + m[3]
+		}
+		return m[3]
+	}
+	return ""
+}
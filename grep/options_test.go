@@ -0,0 +1,95 @@
+package grep
+
+import (
+	"testing"
+)
+
+func TestHelperOption(t *testing.T) {
+	opts := map[string]struct{}{"X11": {}, "DOCS": {}, "X11_GTK": {}}
+
+	examples := []struct {
+		name, option string
+	}{
+		{"X11_LIB_DEPENDS", "X11"},
+		{"X11_GTK_USES", "X11_GTK"},
+		{"DOCS_USES_OFF", "!DOCS"},
+		{"LIB_DEPENDS", ""},
+		{"X11LIB_DEPENDS", ""},
+	}
+
+	for i, x := range examples {
+		if res := helperOption(x.name, opts); res != x.option {
+			t.Errorf("[%d] expected option of %q to be %q, got %q", i, x.name, x.option, res)
+		}
+	}
+}
+
+func TestConditionOption(t *testing.T) {
+	examples := []struct {
+		conds  []string
+		option string
+	}{
+		{[]string{".if ${PORT_OPTIONS:MX11}"}, "X11"},
+		{[]string{".if !${PORT_OPTIONS:MX11}"}, "!X11"},
+		{[]string{".if empty(PORT_OPTIONS:MX11)"}, "!X11"},
+		{[]string{".if !empty(PORT_OPTIONS:MX11)"}, "X11"},
+		{[]string{".else (.if ${PORT_OPTIONS:MX11})"}, "!X11"},
+		{[]string{".if ${PORT_OPTIONS:MX11}", ".if ${ARCH} == i386"}, "X11"},
+		{[]string{".if ${PORT_OPTIONS:MX11}", ".elif ${PORT_OPTIONS:MDOCS}"}, "DOCS"},
+		{[]string{".if ${ARCH} == i386"}, ""},
+		{nil, ""},
+	}
+
+	for i, x := range examples {
+		if res := conditionOption(x.conds); res != x.option {
+			t.Errorf("[%d] expected option of %q to be %q, got %q", i, x.conds, x.option, res)
+		}
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	texts := []*Text{NewText("Makefile", []byte(`OPTIONS_DEFINE=	DOCS
+OPTIONS_SINGLE_GUI=	X11
+X11_LIB_DEPENDS=	libX11.so:x11/libX11 \
+	libXext.so:x11/libXext
+LIB_DEPENDS=	libfoo.so:devel/foo
+.if ${PORT_OPTIONS:MDOCS}
+USES+=	gettext
+.endif
+`))}
+
+	results := Results{
+		{File: "Makefile", Line: 4},
+		{File: "Makefile", Line: 5},
+		{File: "Makefile", Line: 7, Conditions: []string{".if ${PORT_OPTIONS:MDOCS}"}},
+	}
+	setOptions(results, texts)
+
+	for i, option := range []string{"X11", "", "DOCS"} {
+		if results[i].Option != option {
+			t.Errorf("[%d] expected option %q, got %q", i, option, results[i].Option)
+		}
+	}
+}
+
+func TestSearchOptions(t *testing.T) {
+	rx, err := Patterns.Get('l', "libfoo").Compile(1, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := searchMakefile(t, `OPTIONS_DEFINE=	DOCS X11
+X11_LIB_DEPENDS=	libfoo.so:devel/foo
+DOCS_LIB_DEPENDS=	libfoo.so:devel/foo
+`, rx, GallMatches)
+
+	// context of both matches overlaps, but they are pulled in by different
+	// options
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results, got %v", res)
+	}
+	for i, option := range []string{"X11", "DOCS"} {
+		if res[i].Option != option {
+			t.Errorf("[%d] expected option %q, got %q", i, option, res[i].Option)
+		}
+	}
+}
@@ -1,35 +1,228 @@
 package grep
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
 )
 
 type Regexp struct {
-	re  *regexp.Regexp // compiled regexp
-	qsi int            // query subexpression index
-	rsi int            // result subexpression index
+	re        *regexp.Regexp // compiled regexp
+	qsi       int            // query subexpression index
+	rsi       int            // result subexpression index
+	ctxBefore int            // lines of context before match, or ContextBlock
+	ctxAfter  int            // lines of context after match, or ContextBlock
+	lits      [][]byte       // literals required by re, see requiredLiterals
+
+	// if name is set, Regexp matches variable assignments with names
+	// matching name and values, or value words if words is set, matching
+	// re.  The whole variable name is the query submatch.
+	name  *regexp.Regexp
+	words bool
 }
 
+// ContextBlock can be passed to Compile as the number of context lines to
+// extend match context up to the nearest blank line, showing the whole block
+// of variable assignments containing the match.
+const ContextBlock = -1
+
+// Match returns the first match of r in text, or nil if there is no match.
+// Text is searched like Search searches port files, as a Makefile, with
+// continuation lines joined.
 func (r *Regexp) Match(text []byte) (*Result, error) {
-	smi := r.re.FindSubmatchIndex(text)
-	if smi == nil {
+	res, err := r.matchBytes(text, 1)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// MatchAll returns all successive non-overlapping matches of r in text, or
+// nil if there is no match.  Matches with overlapping context, or on the
+// same line, are merged into a single Result.
+func (r *Regexp) MatchAll(text []byte) (Results, error) {
+	res, err := r.matchBytes(text, -1)
+	if err != nil {
+		return nil, err
+	}
+	return mergeResults(res), nil
+}
+
+// matchBytes returns up to n matches of r in text with their positions set.
+func (r *Regexp) matchBytes(text []byte, n int) (Results, error) {
+	t := NewText("", text)
+	res, err := r.matchText(t, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range res {
+		m.Text = t.Restore(m.Text)
+		m.setPosition(t)
+	}
+	return res, nil
+}
+
+// withoutContext returns a copy of r matching without context lines.
+func (r *Regexp) withoutContext() *Regexp {
+	c := *r
+	c.ctxBefore, c.ctxAfter = 0, 0
+	return &c
+}
+
+// matchText returns up to n matches of r in t, using tokens cached by t.
+func (r *Regexp) matchText(t *Text, n int) (Results, error) {
+	if !r.mayMatch(t.Bytes()) {
+		return nil, nil
+	}
+	if r.name != nil {
+		return r.matchTokens(t.Bytes(), t.Tokens(), n), nil
+	}
+	return r.matchRegexp(t.Bytes(), n)
+}
+
+// span describes one match, all indices are byte offsets in the searched
+// text.  Submatch indices are -1 if there's no submatch.
+type span struct {
+	start, end int
+	q, r       [2]int
+}
+
+func (r *Regexp) matchRegexp(text []byte, n int) (Results, error) {
+	smis := r.re.FindAllSubmatchIndex(text, n)
+	if smis == nil {
 		return nil, nil
 	}
-	if len(smi) <= r.rsi {
-		return nil, fmt.Errorf("unexpected number of subexpressions %d in %v", len(smi), r)
+
+	spans := make([]span, 0, len(smis))
+	for _, smi := range smis {
+		if len(smi) <= 2*r.rsi+1 {
+			return nil, fmt.Errorf("unexpected number of subexpressions %d in %v", len(smi), r)
+		}
+		spans = append(spans, span{
+			start: smi[0],
+			end:   smi[1],
+			q:     [2]int{smi[2*r.qsi], smi[2*r.qsi+1]},
+			r:     [2]int{smi[2*r.rsi], smi[2*r.rsi+1]},
+		})
+	}
+
+	return r.results(text, spans), nil
+}
+
+// matchTokens matches variable assignments in tokens of text.
+func (r *Regexp) matchTokens(text []byte, tokens []*makefile.Token, n int) Results {
+	var spans []span
+
+	for _, tok := range tokens {
+		if n > 0 && len(spans) >= n {
+			break
+		}
+		if tok.Type != makefile.Tassignment || !r.name.MatchString(tok.Name) {
+			continue
+		}
+
+		q := [2]int{tok.NameOffset, tok.NameOffset + len(tok.Name)}
+		match := func(start, end int) bool {
+			smi := r.re.FindSubmatchIndex(text[start:end])
+			if smi == nil || smi[2*r.rsi] < 0 {
+				return false
+			}
+			spans = append(spans, span{
+				start: tok.Offset,
+				end:   tok.End,
+				q:     q,
+				r:     [2]int{start + smi[2*r.rsi], start + smi[2*r.rsi+1]},
+			})
+			return true
+		}
+
+		if !r.words {
+			match(tok.ValueOffset, tok.ValueEnd)
+			continue
+		}
+		for _, w := range tok.Words {
+			if match(w.Offset, w.End) && n > 0 && len(spans) >= n {
+				break
+			}
+		}
 	}
-	res := &Result{
-		Text: text[smi[0]:smi[1]],
+
+	if spans == nil {
+		return nil
 	}
-	if r.qsi >= 0 {
-		res.QuerySubmatch = []int{smi[2*r.qsi] - smi[0], smi[2*r.qsi+1] - smi[0]}
+	return r.results(text, spans)
+}
+
+// results returns a Result for each of spans found in text, with context
+// lines added.  Results are not merged, see mergeResults.
+func (r *Regexp) results(text []byte, spans []span) Results {
+	res := make(Results, 0, len(spans))
+
+	for _, sp := range spans {
+		s := contextStart(text, sp.start, r.ctxBefore)
+		e := contextEnd(text, sp.end, r.ctxAfter)
+
+		m := &Result{Text: text[s:e], Offset: s}
+		if sp.q[0] >= 0 {
+			m.QuerySubmatch = []int{sp.q[0] - s, sp.q[1] - s}
+		}
+		if sp.r[0] >= 0 {
+			m.ResultSubmatch = []int{sp.r[0] - s, sp.r[1] - s}
+		}
+		res = append(res, m)
 	}
-	if r.rsi >= 0 {
-		res.ResultSubmatch = []int{smi[2*r.rsi] - smi[0], smi[2*r.rsi+1] - smi[0]}
+
+	return res
+}
+
+// contextStart returns the start index of n lines of context preceding the
+// line containing text[i].  Lines are logical lines, continuations are
+// expected to be joined by Text.
+func contextStart(text []byte, i, n int) int {
+	block := n == ContextBlock
+	s := bytes.LastIndexByte(text[:i], '\n') + 1
+	for ; (block || n > 0) && s > 0; n-- {
+		p := bytes.LastIndexByte(text[:s-1], '\n') + 1
+		if block && isBlank(text[p:s]) {
+			break
+		}
+		s = p
 	}
-	return res, nil
+	return s
+}
+
+// contextEnd returns the end index of n lines of context following the line
+// containing text[i-1].  Lines are logical lines, continuations are expected
+// to be joined by Text.
+func contextEnd(text []byte, i, n int) int {
+	e := i
+	if e == 0 || text[e-1] != '\n' {
+		// match ends mid-line, include the rest of it
+		j := bytes.IndexByte(text[e:], '\n')
+		if j < 0 {
+			return len(text)
+		}
+		e += j + 1
+	}
+	block := n == ContextBlock
+	for ; (block || n > 0) && e < len(text); n-- {
+		next := len(text)
+		if j := bytes.IndexByte(text[e:], '\n'); j >= 0 {
+			next = e + j + 1
+		}
+		if block && isBlank(text[e:next]) {
+			break
+		}
+		e = next
+	}
+	return e
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0
 }
 
 type Pattern interface {
@@ -38,7 +231,13 @@ type Pattern interface {
 	Compile(ctxBefore, ctxAfter int, quote bool) (*Regexp, error)
 
 	optionString() string
-	setQuery(query string)
+	withQuery(query string) Pattern
+}
+
+// describe returns the pattern option and its argument in a fixed-width
+// field followed by desc, as shown in the usage text.
+func describe(opt byte, arg, desc string) string {
+	return fmt.Sprintf("%-11s %s", fmt.Sprintf("-%c %s", opt, arg), desc)
 }
 
 const (
@@ -73,8 +272,13 @@ type stringPattern struct {
 	opt   byte
 	pref  string
 	desc  string
-	pat   string
-	query string
+	name  string // variable name regexp, if pattern matches assignments
+	pat   string // query regexp, matched against values if name is set
+	words bool   // match pat against value words instead of the whole value
+	// flavorPat is used instead of pat for queries with "@flavor" suffix, it
+	// takes origin and flavor queries
+	flavorPat string
+	query     string
 }
 
 func (p *stringPattern) Option() byte {
@@ -83,17 +287,19 @@ func (p *stringPattern) Option() byte {
 
 func (p *stringPattern) Description() string {
 	if p.pref != "" {
-		return fmt.Sprintf("-%c %s:query  %s", p.opt, p.pref, p.desc)
+		return describe(p.opt, p.pref+":query", p.desc)
 	}
-	return fmt.Sprintf("-%c query    %s", p.opt, p.desc)
+	return describe(p.opt, "query", p.desc)
 }
 
 func (p *stringPattern) optionString() string {
 	return string(p.opt) + ":"
 }
 
-func (p *stringPattern) setQuery(query string) {
-	p.query = query
+func (p *stringPattern) withQuery(query string) Pattern {
+	c := *p
+	c.query = query
+	return &c
 }
 
 func (p *stringPattern) Compile(ctxBefore, ctxAfter int, quote bool) (*Regexp, error) {
@@ -101,18 +307,62 @@ func (p *stringPattern) Compile(ctxBefore, ctxAfter int, quote bool) (*Regexp, e
 	if quote {
 		q = regexp.QuoteMeta(q)
 	}
-	re, qsi, rsi, err := compile(fmt.Sprintf(p.pat, ctxBefore, q, ctxAfter))
+	if p.name != "" {
+		pat := fmt.Sprintf(p.pat, q)
+		if i := strings.LastIndexByte(q, '@'); p.flavorPat != "" && i > 0 && i < len(q)-1 {
+			pat = fmt.Sprintf(p.flavorPat, q[:i], q[i+1:])
+		}
+		return compileAssignment(p.name, pat, p.words, ctxBefore, ctxAfter)
+	}
+	re, qsi, rsi, err := compile(fmt.Sprintf(p.pat, q))
+	if err != nil {
+		return nil, err
+	}
+	return &Regexp{
+		re:        re,
+		qsi:       qsi,
+		rsi:       rsi,
+		ctxBefore: ctxBefore,
+		ctxAfter:  ctxAfter,
+		lits:      requiredLiterals(re),
+	}, nil
+}
+
+// compileAssignment returns Regexp matching variable assignments with whole
+// names matching name and values, or value words if words is true, matching
+// pat.  pat must have the result subexpression.
+func compileAssignment(name, pat string, words bool, ctxBefore, ctxAfter int) (*Regexp, error) {
+	nre, err := regexp.Compile(`^(?:` + name + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pat)
 	if err != nil {
 		return nil, err
 	}
-	return &Regexp{re, qsi, rsi}, nil
+	rsi := re.SubexpIndex(rsn)
+	if rsi < 0 {
+		return nil, fmt.Errorf("invalid subexpressions: %s", re)
+	}
+
+	return &Regexp{
+		re:        re,
+		qsi:       -1,
+		rsi:       rsi,
+		ctxBefore: ctxBefore,
+		ctxAfter:  ctxAfter,
+		lits:      requiredLiterals(nre, re),
+		name:      nre,
+		words:     words,
+	}, nil
 }
 
 type boolPattern struct {
 	opt  byte
 	pref string
 	desc string
-	pat  string
+	name string // variable name regexp
+	pat  string // value regexp
 }
 
 func (p *boolPattern) Option() byte {
@@ -121,13 +371,13 @@ func (p *boolPattern) Option() byte {
 
 func (p *boolPattern) Description() string {
 	if p.pref != "" {
-		return fmt.Sprintf("-%c %s        %s", p.opt, p.pref, p.desc)
+		return describe(p.opt, p.pref, p.desc)
 	}
-	return fmt.Sprintf("-%c          %s", p.opt, p.desc)
+	return describe(p.opt, "", p.desc)
 }
 
-func (p *boolPattern) setQuery(query string) {
-	// noop
+func (p *boolPattern) withQuery(query string) Pattern {
+	return p
 }
 
 func (p *boolPattern) optionString() string {
@@ -135,11 +385,45 @@ func (p *boolPattern) optionString() string {
 }
 
 func (p *boolPattern) Compile(ctxBefore, ctxAfter int, quote bool) (*Regexp, error) {
-	re, qsi, rsi, err := compile(fmt.Sprintf(p.pat, ctxBefore, ctxAfter))
-	if err != nil {
-		return nil, err
+	return compileAssignment(p.name, p.pat, false, ctxBefore, ctxAfter)
+}
+
+type varPattern struct {
+	opt   byte
+	desc  string
+	name  string // variable name regexp, with a placeholder for VAR
+	pat   string // value regexp
+	query string
+}
+
+func (p *varPattern) Option() byte {
+	return p.opt
+}
+
+func (p *varPattern) Description() string {
+	return describe(p.opt, "VAR=re", p.desc)
+}
+
+func (p *varPattern) optionString() string {
+	return string(p.opt) + ":"
+}
+
+func (p *varPattern) withQuery(query string) Pattern {
+	c := *p
+	c.query = query
+	return &c
+}
+
+func (p *varPattern) Compile(ctxBefore, ctxAfter int, quote bool) (*Regexp, error) {
+	i := strings.IndexByte(p.query, '=')
+	if i <= 0 || strings.ContainsAny(p.query[:i], " \t$") {
+		return nil, fmt.Errorf("invalid variable search %q, expected VAR=query", p.query)
 	}
-	return &Regexp{re, qsi, rsi}, nil
+	name, q := p.query[:i], p.query[i+1:]
+	if quote {
+		q = regexp.QuoteMeta(q)
+	}
+	return compileAssignment(fmt.Sprintf(p.name, regexp.QuoteMeta(name)), fmt.Sprintf(p.pat, q), false, ctxBefore, ctxAfter)
 }
 
 type Registry []Pattern
@@ -152,11 +436,12 @@ func (r Registry) OptionString() string {
 	return b.String()
 }
 
+// Get returns a pattern registered for option opt with its query set to
+// query, or nil if there is no such pattern.
 func (r Registry) Get(opt byte, query string) Pattern {
 	for _, p := range r {
 		if p.Option() == opt {
-			p.setQuery(query)
-			return p
+			return p.withQuery(query)
 		}
 	}
 	return nil
@@ -165,78 +450,127 @@ func (r Registry) Get(opt byte, query string) Pattern {
 func Compile(query string, ctxBefore, ctxAfter int, quote bool) (*Regexp, error) {
 	p := &stringPattern{
 		// no query group, only result
-		pat:   `(?:.*\n){0,%d}.*(?P<q>)(?P<r>%s).*(\n|\z)(?:.*\n){0,%d}`,
+		pat:   `.*(?P<q>)(?P<r>%s).*(\n|\z)`,
 		query: query,
 	}
 	return p.Compile(ctxBefore, ctxAfter, quote)
 }
 
+// depFlavorPat matches dependency origins with a flavor.  Flavor variable
+// references, like "@${PY_FLAVOR}", match any flavor.
+const depFlavorPat = `(^|[:/}])(?P<r>(%s)@(%s|\$\{\w*FLAVOR\}))(:|$)`
+
 var (
 	portname = &stringPattern{
 		opt:  'n',
 		pref: "",
 		desc: "search by PORTNAME",
-		pat:  `(?i)(?:.*\n){0,%d}\b(?P<q>PORTNAME)\s*\??=\s*(?P<r>%s).*(\n|\z)(?:.*\n){0,%d}`,
+		name: `PORTNAME`,
+		pat:  `(?i)^(?P<r>%s)`,
 	}
 	maintainer = &stringPattern{
 		opt:  'm',
 		pref: "",
 		desc: "search by MAINTAINER",
-		pat:  `(?i)(?:.*\n){0,%d}\b(?P<q>MAINTAINER)\s*\??=\s*(?P<r>%s).*(\n|\z)(?:.*\n){0,%d}`,
+		name: `MAINTAINER`,
+		pat:  `(?i)^(?P<r>%s)`,
 	}
 	allDepends = &stringPattern{
-		opt:  'd',
-		pref: "",
-		desc: "search by *_DEPENDS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>(\w+_)?DEPENDS)\s*(\+|\?)?(=|=.*?[\s/}])(?P<r>%s)((\n|\z)|[\s@:>\.].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:       'd',
+		pref:      "",
+		desc:      "search by *_DEPENDS",
+		name:      `(\w+_)?DEPENDS`,
+		pat:       `(^|[:/}])(?P<r>%s)([@:>.]|$)`,
+		words:     true,
+		flavorPat: depFlavorPat,
 	}
 	buildDepends = &stringPattern{
-		opt:  'b',
-		pref: "",
-		desc: "search by BUILD_DEPENDS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>(\w+_)?BUILD_DEPENDS)\s*(\+|\?)?(=|=.*?[\s/}])(?P<r>%s)((\n|\z)|[\s@:>\.].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:       'b',
+		pref:      "",
+		desc:      "search by BUILD_DEPENDS",
+		name:      `(\w+_)?BUILD_DEPENDS`,
+		pat:       `(^|[:/}])(?P<r>%s)([@:>.]|$)`,
+		words:     true,
+		flavorPat: depFlavorPat,
 	}
 	libDepends = &stringPattern{
-		opt:  'l',
-		pref: "",
-		desc: "search by LIB_DEPENDS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>(\w+_)?LIB_DEPENDS)\s*(\+|\?)?(=|=.*?[\s/}])(?P<r>%s)((\n|\z)|[\s@:\.].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:       'l',
+		pref:      "",
+		desc:      "search by LIB_DEPENDS",
+		name:      `(\w+_)?LIB_DEPENDS`,
+		pat:       `(^|[:/}])(?P<r>%s)([@:.]|$)`,
+		words:     true,
+		flavorPat: depFlavorPat,
 	}
 	runDepends = &stringPattern{
-		opt:  'r',
-		pref: "",
-		desc: "search by RUN_DEPENDS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>(\w+_)?RUN_DEPENDS)\s*(\+|\?)?(=|=.*?[\s/}])(?P<r>%s)((\n|\z)|[\s@:>\.].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:       'r',
+		pref:      "",
+		desc:      "search by RUN_DEPENDS",
+		name:      `(\w+_)?RUN_DEPENDS`,
+		pat:       `(^|[:/}])(?P<r>%s)([@:>.]|$)`,
+		words:     true,
+		flavorPat: depFlavorPat,
 	}
 	testDepends = &stringPattern{
-		opt:  't',
-		pref: "",
-		desc: "search by TEST_DEPENDS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>(\w+_)?TEST_DEPENDS)\s*(\+|\?)?(=|=.*?[\s/}])(?P<r>%s)((\n|\z)|[\s@:>\.].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:       't',
+		pref:      "",
+		desc:      "search by TEST_DEPENDS",
+		name:      `(\w+_)?TEST_DEPENDS`,
+		pat:       `(^|[:/}])(?P<r>%s)([@:>.]|$)`,
+		words:     true,
+		flavorPat: depFlavorPat,
 	}
 	onlyForArchs = &stringPattern{
-		opt:  'a',
-		pref: "",
-		desc: "search by ONLY_FOR_ARCHS",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>ONLY_FOR_ARCHS)\s*(\+|\?)?(=|=.*?\s)(?P<r>%s)((\n|\z)|\s.*(\n|\z))(?:.*\n){0,%d}`,
+		opt:   'a',
+		pref:  "",
+		desc:  "search by ONLY_FOR_ARCHS",
+		name:  `ONLY_FOR_ARCHS`,
+		pat:   `^(?P<r>%s)$`,
+		words: true,
 	}
 	uses = &stringPattern{
-		opt:  'u',
-		pref: "",
-		desc: "search by USES",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>([\w_]+_)?USES)\s*(\+|\?)?(=|=.*?\s)(?P<r>%s)((\n|\z)|[\s:,].*(\n|\z))(?:.*\n){0,%d}`,
+		opt:   'u',
+		pref:  "",
+		desc:  "search by USES",
+		name:  `(\w+_)?USES`,
+		pat:   `^(?P<r>%s)([:,].*)?$`,
+		words: true,
 	}
 	plist = &stringPattern{
 		opt:  'p',
 		pref: "",
 		desc: "search by PLIST_FILES",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>([\w_]+_)?PLIST_FILES)\s*(\+|\?)?=.*?(?P<r>%s).*(\n|\z)(?:.*\n){0,%d}`,
+		name: `(\w+_)?PLIST_FILES`,
+		pat:  `(?P<r>%s)`,
+	}
+	variable = &varPattern{
+		opt:  'w',
+		desc: "search by variable VAR, e.g. -w LICENSE=MIT",
+		name: `(\w+_)?%s`,
+		pat:  `(?P<r>%s)`,
+	}
+	optionsDefine = &stringPattern{
+		opt:   'J',
+		pref:  "",
+		desc:  "search by options defined in OPTIONS_DEFINE and option groups",
+		name:  `OPTIONS_(DEFINE(_\w+)?|(GROUP|SINGLE|RADIO|MULTI)_\w+)`,
+		pat:   `^(?P<r>%s)$`,
+		words: true,
+	}
+	optionsDefault = &stringPattern{
+		opt:   'K',
+		pref:  "",
+		desc:  "search by OPTIONS_DEFAULT",
+		name:  `OPTIONS_DEFAULT(_\w+)?`,
+		pat:   `^(?P<r>%s)$`,
+		words: true,
 	}
 	broken = &boolPattern{
 		opt:  'X',
 		pref: "",
 		desc: "search only ports marked BROKEN",
-		pat:  `(?:.*\n){0,%d}\b(?P<q>BROKEN(_[^=]+)?)\s*\??=(?P<r>.*)(\n|\z)(?:.*\n){0,%d}`,
+		name: `BROKEN(_\S+)?`,
+		pat:  `(?P<r>.*)`,
 	}
 )
 
@@ -251,5 +585,8 @@ var Patterns = Registry{
 	onlyForArchs,
 	uses,
 	plist,
+	optionsDefine,
+	optionsDefault,
+	variable,
 	broken,
 }
@@ -1,6 +1,7 @@
 package grep
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -203,6 +204,9 @@ func TestUses(t *testing.T) {
 		"USES=	gmake go xorg",
 		"USES=	gmake go",
 		"VAR_USES=	go",
+		"USES	=	go",
+		"USES=	gmake \\\n		go",
+		".if ${ARCH} == i386\nUSES+=	go\n.endif",
 	}
 
 	nomatches := []string{
@@ -213,6 +217,10 @@ func TestUses(t *testing.T) {
 		"USES=	go-test",
 		"USES=	go.test",
 		"XUSES=	go",
+		"USES=	gmake # go",
+		"# USES=	go",
+		".if ${USES:Mgo}\n.endif",
+		"do-build:\n	@${ECHO} USES=go",
 	}
 
 	testStringPattern(t, uses, "go", false, matches, nomatches)
@@ -232,3 +240,255 @@ func TestPlistRe(t *testing.T) {
 
 	testStringPattern(t, plist, "bash", false, matches, nomatches)
 }
+
+func TestMatchAll(t *testing.T) {
+	text := []byte(`LIB_DEPENDS=	libfoo.so:devel/foo
+USES=		gmake
+FOO_LIB_DEPENDS=	libfoo.so:devel/foo
+
+COMMENT=	Foo
+.if ${ARCH} == i386
+BAR_LIB_DEPENDS=	libfoo.so:devel/foo
+.endif
+`)
+
+	libDepends.query = "libfoo"
+
+	r, err := libDepends.Compile(0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.MatchAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("expected 3 results, got %d: %v", len(res), res)
+	}
+	for i, m := range res {
+		if len(m.QuerySubmatch) != 2 || len(m.ResultSubmatch) != 2 {
+			t.Errorf("[#%d] expected one submatch pair, got %v", i, m)
+		}
+		if got := string(m.Text[m.ResultSubmatch[0]:m.ResultSubmatch[1]]); got != "libfoo" {
+			t.Errorf("[#%d] expected result submatch %q, got %q", i, "libfoo", got)
+		}
+	}
+
+	// context of the first two matches overlaps and is merged
+	r, err = libDepends.Compile(1, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = r.MatchAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results, got %d: %v", len(res), res)
+	}
+	if len(res[0].QuerySubmatch) != 4 || len(res[0].ResultSubmatch) != 4 {
+		t.Errorf("expected two submatch pairs, got %v", res[0])
+	}
+	if exp := string(text[:bytes.Index(text, []byte("\n\n"))+2]); string(res[0].Text) != exp {
+		t.Errorf("expected merged text %q, got %q", exp, string(res[0].Text))
+	}
+
+	// matches on adjacent lines are reported separately without context, the
+	// text formatter prints them as one block
+	text = []byte("FOO_LIB_DEPENDS=	libfoo.so:devel/foo\nBAR_LIB_DEPENDS=	libfoo.so:devel/foo\nBAZ_LIB_DEPENDS=	libfoo.so:devel/foo\n")
+	r, err = libDepends.Compile(0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = r.MatchAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("expected 3 results, got %d: %v", len(res), res)
+	}
+	for i, name := range []string{"FOO_", "BAR_", "BAZ_"} {
+		if exp := bytes.Index(text, []byte(name)); res[i].Offset != exp {
+			t.Errorf("[#%d] expected offset %d, got %d", i, exp, res[i].Offset)
+		}
+	}
+}
+
+func TestMatchBlock(t *testing.T) {
+	text := []byte(`PORTNAME=	foo
+
+RUN_DEPENDS=	bar:devel/bar
+LIB_DEPENDS=	libbar.so:devel/bar \
+		libfoo.so:devel/foo \
+		libbaz.so:devel/baz
+USES=		gmake
+
+COMMENT=	Foo
+`)
+
+	examples := []struct {
+		opt   byte
+		query string
+		block string
+	}{
+		// the block stops at blank lines and keeps continued assignments
+		// whole
+		{'l', "libfoo", "RUN_DEPENDS=\tbar:devel/bar\nLIB_DEPENDS=\tlibbar.so:devel/bar \\\n\t\tlibfoo.so:devel/foo \\\n\t\tlibbaz.so:devel/baz\nUSES=\t\tgmake\n"},
+		{'u', "gmake", "RUN_DEPENDS=\tbar:devel/bar\nLIB_DEPENDS=\tlibbar.so:devel/bar \\\n\t\tlibfoo.so:devel/foo \\\n\t\tlibbaz.so:devel/baz\nUSES=\t\tgmake\n"},
+		// blocks at the start and at the end of text
+		{'n', "foo", "PORTNAME=\tfoo\n"},
+		{'w', "COMMENT=Foo", "COMMENT=\tFoo\n"},
+	}
+
+	for i, x := range examples {
+		r, err := Patterns.Get(x.opt, x.query).Compile(ContextBlock, ContextBlock, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := r.MatchAll(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 {
+			t.Fatalf("[#%d] expected 1 result, got %d: %v", i, len(res), res)
+		}
+		if string(res[0].Text) != x.block {
+			t.Errorf("[#%d] expected block %q, got %q", i, x.block, res[0].Text)
+		}
+	}
+}
+
+func TestMatchPosition(t *testing.T) {
+	text := []byte("PORTNAME=	foo\nUSES=	cmake \\\n	go:modules\n")
+
+	rx, err := Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := rx.Match(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		t.Fatal("expected match")
+	}
+	if m.Line != 3 || m.Column != 2 {
+		t.Errorf("expected match on line 3, column 2, got %d and %d", m.Line, m.Column)
+	}
+	if exp := "USES=\tcmake \\\n\tgo:modules\n"; string(m.Text) != exp {
+		t.Errorf("expected text %q, got %q", exp, m.Text)
+	}
+
+	res, err := rx.MatchAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Line != 3 || res[0].Column != 2 {
+		t.Errorf("expected one match on line 3, column 2, got %v", res)
+	}
+}
+
+func TestVariable(t *testing.T) {
+	matches := []string{
+		"LICENSE=	BSD2CLAUSE",
+		"LICENSE+=	BSD2CLAUSE",
+		"LICENSE?=	BSD2CLAUSE",
+		"LICENSE:=	BSD2CLAUSE",
+		"LICENSE!=	echo BSD2CLAUSE",
+		"LICENSE =	MIT BSD2CLAUSE",
+		"OPT_LICENSE=	BSD2CLAUSE",
+	}
+
+	nomatches := []string{
+		"LICENSE=	MIT",
+		"XLICENSE=	BSD2CLAUSE",
+		"LICENSE_FILE=	BSD2CLAUSE",
+		"# BSD2CLAUSE LICENSE=",
+	}
+
+	r, err := variable.withQuery("LICENSE=BSD2").Compile(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, x := range matches {
+		res, err := r.Match([]byte(x))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res == nil {
+			t.Errorf("[matches #%d] expected to match %q", i, x)
+		}
+	}
+
+	for i, x := range nomatches {
+		res, err := r.Match([]byte(x))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != nil {
+			t.Errorf("[nomatches #%d] expected to not match %q, got %#v", i, x, res)
+		}
+	}
+
+	for _, q := range []string{"LICENSE", "=BSD2", "MY VAR=x"} {
+		if _, err := variable.withQuery(q).Compile(0, 0, false); err == nil {
+			t.Errorf("expected %q to be rejected", q)
+		}
+	}
+}
+
+func TestOptionsDefine(t *testing.T) {
+	matches := []string{
+		"OPTIONS_DEFINE=	DOCS X11",
+		"OPTIONS_DEFINE_i386=	X11",
+		"OPTIONS_GROUP_GUI=	X11 WAYLAND",
+		"OPTIONS_SINGLE_BACKEND=	GTK X11",
+		"OPTIONS_RADIO_GUI=	X11",
+		"OPTIONS_MULTI_GUI=	X11",
+	}
+
+	nomatches := []string{
+		"OPTIONS_DEFINE=	X11DOCS",
+		"OPTIONS_DEFAULT=	X11",
+		"OPTIONS_GROUP=	X11",
+		"OPTIONS_EXCLUDE=	X11",
+		"X11_USES=	xorg",
+		".if ${PORT_OPTIONS:MX11}\n.endif",
+	}
+
+	testStringPattern(t, optionsDefine, "X11", false, matches, nomatches)
+}
+
+func TestOptionsDefault(t *testing.T) {
+	matches := []string{
+		"OPTIONS_DEFAULT=	DOCS X11",
+		"OPTIONS_DEFAULT_amd64=	X11",
+	}
+
+	nomatches := []string{
+		"OPTIONS_DEFINE=	X11",
+		"OPTIONS_DEFAULT=	X11DOCS",
+		"OPTIONS_DEFAULT+=	# X11",
+	}
+
+	testStringPattern(t, optionsDefault, "X11", false, matches, nomatches)
+}
+
+func TestDependsFlavor(t *testing.T) {
+	matches := []string{
+		"BUILD_DEPENDS=	py39-setuptools>0:devel/py-setuptools@py39",
+		"RUN_DEPENDS=	${PYTHON_PKGNAMEPREFIX}setuptools>0:devel/py-setuptools@${PY_FLAVOR}",
+		"BUILD_DEPENDS=	py39-setuptools>0:devel/py-setuptools@py39:install",
+		"py39_BUILD_DEPENDS=	py39-setuptools>0:${PORTSDIR}/devel/py-setuptools@py39",
+	}
+
+	nomatches := []string{
+		"BUILD_DEPENDS=	py310-setuptools>0:devel/py-setuptools@py310",
+		"BUILD_DEPENDS=	py39-setuptools>0:devel/py-setuptools",
+		"BUILD_DEPENDS=	py39-setuptools>0:devel/py-setuptools@py3",
+		"BUILD_DEPENDS=	py39-setuptools_scm>0:devel/py-setuptools_scm@py39",
+	}
+
+	testStringPattern(t, allDepends, "devel/py-setuptools@py39", false, matches, nomatches)
+}
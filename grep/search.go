@@ -0,0 +1,102 @@
+package grep
+
+import (
+	"context"
+	"errors"
+	"runtime"
+)
+
+// Options describes a search run by Search.
+type Options struct {
+	// PortsRoot is the ports tree root
+	PortsRoot string
+	// Categories limits the search to these categories, if not empty
+	Categories []string
+	// Files lists port files to search, glob patterns are allowed, like
+	// "files/patch-*".  Missing files are skipped.  If empty, DefaultFiles
+	// are searched.
+	Files []string
+	// FileReader, if not nil, is used to read port files instead of reading
+	// them from disk
+	FileReader FileReader
+	// Expr is the expression ports have to match, if nil all ports match
+	Expr Expr
+	// Flags is a combination of G* flags:
+	// If GfollowIncludes is set, files included with .include directives
+	// from inside the ports tree (excluding the framework in Mk) are
+	// searched too, and files missing in slave ports are searched for in
+	// their MASTERDIR.
+	// If GexpandVars is set, variable assignments referencing other
+	// variables are searched again with references expanded, so that, for
+	// example, "lib${PORTNAME}.so" matches "libfoo.so".
+	// If GallMatches is set, every non-overlapping match is reported.
+	// If Ginvert is set, only ports not matching Expr are reported, without
+	// any results.  Ports without any of Files don't match, so they are
+	// reported too.
+	// If Gsorted is set, results are reordered and passed to Func in the
+	// port origin order as soon as all preceding ports have been searched.
+	Flags int
+	// Func is called for each matching port, it's required
+	Func GrepFunc
+	// MaxJobs is the number of goroutines searching ports.  If 0,
+	// runtime.NumCPU() goroutines are used.
+	MaxJobs int
+}
+
+// Search looks for ports matching opts.Expr, like Grep does, and calls
+// opts.Func for each of them.  The search stops early if opts.Func returns
+// an error, or Stop, or if ctx is cancelled, in which case ctx.Err() is
+// returned.  All goroutines started by Search have exited when it returns.
+func Search(ctx context.Context, opts Options) error {
+	if opts.Func == nil {
+		return errors.New("nil Options.Func")
+	}
+	files := opts.Files
+	if len(files) == 0 {
+		files = DefaultFiles
+	}
+	maxJobs := opts.MaxJobs
+	if maxJobs <= 0 {
+		maxJobs = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	walkCh, err := walk(ctx, opts.PortsRoot, opts.Categories, opts.FileReader, opts.Flags&Gsorted != 0, maxJobs)
+	if err != nil {
+		return err
+	}
+	grepCh, err := walkCh.grep(ctx, opts.PortsRoot, files, opts.FileReader, opts.Expr, opts.Flags, maxJobs)
+	if err != nil {
+		return err
+	}
+	if opts.Flags&Gsorted != 0 {
+		grepCh = grepCh.reorder(ctx)
+	}
+	// stop the search and wait for it to finish on return
+	defer grepCh.drain(cancel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case x, ok := <-grepCh:
+			if !ok {
+				return ctx.Err()
+			}
+			if x.path == "" && x.err == nil {
+				continue // port didn't match
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := opts.Func(x.path, x.results, x.err); err != nil {
+				if err == Stop {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
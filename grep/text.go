@@ -0,0 +1,173 @@
+package grep
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/dmgk/portgrep/makefile"
+)
+
+// Text is a Makefile split into logical lines.  Backslash-newline
+// continuations are joined, so that regular expressions and context lines
+// see a whole multi-line variable assignment as a single line.  Text keeps
+// track of physical line numbers of each logical line.
+type Text struct {
+	// Name is the name of the file text was read from, relative to the port
+	// directory
+	Name string
+
+	buf    []byte // contents with continuations replaced by two NUL bytes
+	starts []int  // start offsets of logical lines
+	lines  []int  // physical line numbers of logical line starts
+
+	tokens    []*makefile.Token
+	tokenized bool
+
+	source *Text // text this one was derived from by expanding variables
+}
+
+// NewText returns Text holding a copy of b, read from the file name.
+func NewText(name string, b []byte) *Text {
+	t := &Text{
+		Name:   name,
+		buf:    make([]byte, len(b)),
+		starts: []int{0},
+		lines:  []int{1},
+	}
+
+	line := 1
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) && b[i+1] == '\n' {
+			t.buf[i], t.buf[i+1] = 0, 0
+			i++
+			line++
+			continue
+		}
+		t.buf[i] = b[i]
+		if b[i] == '\n' {
+			line++
+			if i+1 < len(b) {
+				t.starts = append(t.starts, i+1)
+				t.lines = append(t.lines, line)
+			}
+		}
+	}
+
+	return t
+}
+
+// Bytes returns text contents with continuation lines joined.  The returned
+// slice has the same length as the original contents, so offsets into it are
+// valid offsets into the original text.
+func (t *Text) Bytes() []byte {
+	return t.buf
+}
+
+// LogicalLines returns the number of logical lines in t.
+func (t *Text) LogicalLines() int {
+	return len(t.starts)
+}
+
+// Line returns the physical line number (starting from 1) of the byte at
+// offset.
+func (t *Text) Line(offset int) int {
+	i := t.logicalLine(offset)
+	return t.lines[i] + bytes.Count(t.buf[t.starts[i]:offset], continuation)
+}
+
+// logicalLine returns the index of logical line containing the byte at
+// offset.
+func (t *Text) logicalLine(offset int) int {
+	return sort.SearchInts(t.starts, offset+1) - 1
+}
+
+// Restore returns a copy of b, a slice of t.Bytes(), with continuation lines
+// split back.
+func (t *Text) Restore(b []byte) []byte {
+	return restore(b)
+}
+
+// Tokens returns Makefile tokens of t.  Token offsets are valid offsets into
+// t.Bytes().
+func (t *Text) Tokens() []*makefile.Token {
+	if !t.tokenized {
+		t.tokens = makefile.Tokenize(restore(t.buf))
+		t.tokenized = true
+	}
+	return t.tokens
+}
+
+// conditions returns conditional directives enclosing the physical line,
+// outermost first.  Branches of .if are returned as the .if, .elif or .else
+// directives starting them, .else is followed by the .if it belongs to, like
+// ".else (.if ${ARCH} == i386)".
+func (t *Text) conditions(line int) []string {
+	if t.source != nil {
+		return t.source.conditions(line)
+	}
+
+	type frame struct {
+		opener string // .if directive opening the conditional
+		branch string // directive starting the current branch
+	}
+	var stack []frame
+
+	for _, tok := range t.Tokens() {
+		if tok.Line >= line {
+			break
+		}
+		if tok.Type != makefile.Tconditional {
+			continue
+		}
+
+		d := "." + tok.Name
+		if tok.Value != "" {
+			d += " " + tok.Value
+		}
+		switch {
+		case strings.HasPrefix(tok.Name, "if"):
+			stack = append(stack, frame{d, d})
+		case len(stack) == 0:
+			// unbalanced .elif, .else or .endif
+		case tok.Name == "endif":
+			stack = stack[:len(stack)-1]
+		case tok.Name == "else":
+			stack[len(stack)-1].branch = d + " (" + stack[len(stack)-1].opener + ")"
+		default:
+			stack[len(stack)-1].branch = d
+		}
+	}
+
+	if len(stack) == 0 {
+		return nil
+	}
+	res := make([]string, len(stack))
+	for i, f := range stack {
+		res[i] = f.branch
+	}
+	return res
+}
+
+// assignmentAt returns the variable assignment spanning the physical line, or
+// nil.
+func (t *Text) assignmentAt(line int) *makefile.Token {
+	for _, tok := range t.Tokens() {
+		if tok.Line > line {
+			break
+		}
+		if tok.Type != makefile.Tassignment {
+			continue
+		}
+		if last := tok.Line + bytes.Count(t.buf[tok.Offset:tok.End], continuation); line <= last {
+			return tok
+		}
+	}
+	return nil
+}
+
+func restore(b []byte) []byte {
+	return bytes.ReplaceAll(b, continuation, []byte{'\\', '\n'})
+}
+
+var continuation = []byte{0, 0}
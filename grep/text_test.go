@@ -0,0 +1,72 @@
+package grep
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	src := []byte("PORTNAME=	foo\nLIB_DEPENDS=	libbar.so:devel/bar \\\n		libbaz.so:devel/baz\n\nUSES=	go\n")
+	txt := NewText("Makefile", src)
+
+	if n := txt.LogicalLines(); n != 4 {
+		t.Errorf("expected 4 logical lines, got %d", n)
+	}
+	if bytes.Count(txt.Bytes(), []byte{'\n'}) != 4 {
+		t.Errorf("expected continuation to be joined, got %q", txt.Bytes())
+	}
+
+	examples := []struct {
+		s    string
+		line int
+	}{
+		{"PORTNAME", 1},
+		{"LIB_DEPENDS", 2},
+		{"libbaz", 3},
+		{"USES", 5},
+	}
+	for i, x := range examples {
+		if l := txt.Line(bytes.Index(src, []byte(x.s))); l != x.line {
+			t.Errorf("[#%d] expected %q to be on line %d, got %d", i, x.s, x.line, l)
+		}
+	}
+
+	if r := txt.Restore(txt.Bytes()); !bytes.Equal(r, src) {
+		t.Errorf("expected restored text to be %q, got %q", src, r)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	text := NewText("Makefile", []byte(`USES=	go
+.if ${ARCH} == i386
+BROKEN=	i386
+.  if ${OPSYS} == FreeBSD
+USES+=	gmake
+.  endif
+.elif ${ARCH} == amd64
+USES+=	cmake
+.else
+USES+=	ninja
+.endif
+USES+=	pkgconfig
+`))
+
+	examples := []struct {
+		line int
+		exp  []string
+	}{
+		{1, nil},
+		{3, []string{".if ${ARCH} == i386"}},
+		{5, []string{".if ${ARCH} == i386", ".if ${OPSYS} == FreeBSD"}},
+		{8, []string{".elif ${ARCH} == amd64"}},
+		{10, []string{".else (.if ${ARCH} == i386)"}},
+		{12, nil},
+	}
+
+	for i, x := range examples {
+		if conds := text.conditions(x.line); !reflect.DeepEqual(conds, x.exp) {
+			t.Errorf("[#%d] expected line %d conditions %q, got %q", i, x.line, x.exp, conds)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+// BenchmarkGrep compares searching a synthetic ports tree on disk with
+// searching it through a saved index, including the cost of loading the
+// index.
+func BenchmarkGrep(b *testing.B) {
+	files := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		files[fmt.Sprintf("cat%d/port%d/Makefile", i%50, i)] = fmt.Sprintf("PORTNAME=	port%d\nCATEGORIES=	devel\nMAINTAINER=	ports@FreeBSD.org\n\nLIB_DEPENDS=	libfoo.so:devel/foo\nUSES=		cmake pkgconfig\n\n.include <bsd.port.mk>\n", i)
+	}
+	root := writePorts(b, files)
+	path := filepath.Join(b.TempDir(), "ports.idx")
+
+	x, err := Build(path, root, nil, grep.DefaultFiles, 0, nil, 8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := x.Save(); err != nil {
+		b.Fatal(err)
+	}
+
+	rx, err := grep.Patterns.Get('u', "go").Compile(0, 0, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	gfn := func(string, grep.Results, error) error { return nil }
+
+	b.Run("disk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := grep.Grep(root, nil, []*grep.Regexp{rx}, false, gfn, 8); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			x, err := Open(path, root)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = grep.Search(context.Background(), grep.Options{
+				PortsRoot:  root,
+				FileReader: x,
+				Expr:       grep.Term(rx),
+				Func:       gfn,
+				MaxJobs:    8,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if x.Dirty() {
+				b.Fatal("expected index to be up to date")
+			}
+		}
+	})
+}
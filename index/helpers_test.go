@@ -0,0 +1,31 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePorts writes port files, keyed by their path relative to the ports
+// tree root, to a temporary ports tree and returns its root.  Keys ending
+// with "/" create empty directories.
+func writePorts(t testing.TB, files map[string]string) string {
+	root := t.TempDir()
+	for name, s := range files {
+		path := filepath.Join(root, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
@@ -0,0 +1,351 @@
+// Package index implements a persistent on-disk cache of port files contents
+// and of the ports tree layout, used to speed up repeated searches.
+package index
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dmgk/portgrep/grep"
+)
+
+// version is incremented whenever index file format changes.
+const version = 2
+
+type entry struct {
+	ModTime int64
+	Size    int64
+	Data    []byte
+}
+
+// dirEntry is a cached directory listing.
+type dirEntry struct {
+	ModTime int64
+	Names   []string
+}
+
+type indexFile struct {
+	Version int
+	Entries map[string]*entry
+	Dirs    map[string]*dirEntry
+}
+
+// Index holds contents of port files and listings of category and port
+// directories, keyed by their path relative to the ports tree root.  File
+// entries are invalidated by file modification time and size, directory
+// entries by directory modification time, which changes whenever ports are
+// added or removed.  Serving directory listings from the index saves reading
+// every directory of the ports tree on each search.  Entries of deleted files
+// and directories are dropped when the index is saved.  Index implements
+// grep.DirReader.
+type Index struct {
+	path string
+	root string
+
+	mu      sync.RWMutex // protects entries, dirs, used and dirty
+	entries map[string]*entry
+	dirs    map[string]*dirEntry
+	used    map[string]struct{} // keys of entries read since Open
+	dirty   bool
+}
+
+// DefaultPath returns the default index file path for the ports tree at
+// root, in $XDG_CACHE_HOME/portgrep.  The file name is derived from the hash
+// of the absolute root path.
+func DefaultPath(root string) (string, error) {
+	cacheDir := os.Getenv("XDG_CACHE_HOME")
+	if cacheDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		cacheDir = filepath.Join(home, ".cache")
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := hex.EncodeToString(sum[:8])
+
+	return filepath.Join(cacheDir, "portgrep", name+".idx"), nil
+}
+
+// New returns a new empty index for the ports tree at root, to be saved at
+// path.
+func New(path, root string) *Index {
+	return &Index{
+		path:    path,
+		root:    root,
+		entries: make(map[string]*entry),
+		dirs:    make(map[string]*dirEntry),
+		used:    make(map[string]struct{}),
+	}
+}
+
+// Open loads the index at path for the ports tree at root.  If the index
+// doesn't exist, is corrupt or was written by an incompatible version, Open
+// returns an empty index, which is filled as port files are read.
+func Open(path, root string) (*Index, error) {
+	x := New(path, root)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return x, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	xf, err := decode(f)
+	if err != nil || xf.Version != version || xf.Entries == nil || xf.Dirs == nil {
+		// rebuild the index on Save
+		x.dirty = true
+		return x, nil
+	}
+	x.entries, x.dirs = xf.Entries, xf.Dirs
+
+	return x, nil
+}
+
+func decode(f *os.File) (*indexFile, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var xf indexFile
+	if err := gob.NewDecoder(zr).Decode(&xf); err != nil {
+		return nil, err
+	}
+	return &xf, zr.Close()
+}
+
+// ReadFile returns contents of the file at path.  If the index has an
+// up-to-date entry for path, its contents are returned without reading the
+// file, otherwise the file is read and the index is updated.
+func (x *Index) ReadFile(path string) ([]byte, error) {
+	key, err := filepath.Rel(x.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			x.remove(key)
+		}
+		return nil, err
+	}
+
+	x.mu.Lock()
+	e := x.entries[key]
+	if e != nil && e.ModTime == fi.ModTime().UnixNano() && e.Size == fi.Size() {
+		x.used[key] = struct{}{}
+		x.mu.Unlock()
+		return e.Data, nil
+	}
+	x.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.Lock()
+	x.entries[key] = &entry{
+		ModTime: fi.ModTime().UnixNano(),
+		Size:    fi.Size(),
+		Data:    data,
+	}
+	x.used[key] = struct{}{}
+	x.dirty = true
+	x.mu.Unlock()
+
+	return data, nil
+}
+
+// ReadDirs returns sorted names of subdirectories of the directory at path.
+// If the index has an up-to-date listing of path, it's returned without
+// reading the directory, otherwise the directory is read and the index is
+// updated.
+func (x *Index) ReadDirs(path string) ([]string, error) {
+	key, err := filepath.Rel(x.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			x.remove(key)
+		}
+		return nil, err
+	}
+
+	x.mu.Lock()
+	d := x.dirs[key]
+	if d != nil && d.ModTime == fi.ModTime().UnixNano() {
+		x.used[key] = struct{}{}
+		x.mu.Unlock()
+		return d.Names, nil
+	}
+	x.mu.Unlock()
+
+	names, err := grep.ReadDirs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.Lock()
+	x.dirs[key] = &dirEntry{
+		ModTime: fi.ModTime().UnixNano(),
+		Names:   names,
+	}
+	x.used[key] = struct{}{}
+	x.dirty = true
+	x.mu.Unlock()
+
+	return names, nil
+}
+
+// remove removes the entry of the deleted file or directory key.
+func (x *Index) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	_, isFile := x.entries[key]
+	_, isDir := x.dirs[key]
+	if isFile || isDir {
+		delete(x.entries, key)
+		delete(x.dirs, key)
+		x.dirty = true
+	}
+}
+
+// prune removes entries of deleted files and directories that were not read
+// since the index was opened, files and directories read are known to exist.
+func (x *Index) prune() {
+	exists := func(key string) bool {
+		if _, ok := x.used[key]; ok {
+			return true
+		}
+		_, err := os.Stat(filepath.Join(x.root, key))
+		return !os.IsNotExist(err)
+	}
+	for key := range x.entries {
+		if !exists(key) {
+			delete(x.entries, key)
+		}
+	}
+	for key := range x.dirs {
+		if !exists(key) {
+			delete(x.dirs, key)
+		}
+	}
+}
+
+// Len returns the number of files in the index.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.entries)
+}
+
+// Dirty reports whether the index was updated since it was loaded or saved.
+func (x *Index) Dirty() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.dirty
+}
+
+// Save writes the index to its path, gzip compressed, dropping entries of
+// deleted files and directories.  The index file is replaced atomically, so
+// concurrent readers never see a partially written index.
+func (x *Index) Save() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.prune()
+
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(x.path), filepath.Base(x.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	xf := indexFile{
+		Version: version,
+		Entries: x.entries,
+		Dirs:    x.dirs,
+	}
+	zw, err := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := gob.NewEncoder(zw).Encode(&xf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), x.path); err != nil {
+		return err
+	}
+
+	x.dirty = false
+	return nil
+}
+
+// Build returns a new index of port files selected by categories, files and
+// flags, as they would be searched by grep.Search.  The index is built from
+// scratch, so it has no entries of deleted files.  Ports that can't be read
+// are passed to efn with the error, they are left out of the index if efn
+// returns nil.  If efn is nil, Build stops at the first error.
+func Build(path, root string, categories, files []string, flags int, efn func(path string, err error) error, maxJobs int) (*Index, error) {
+	x := New(path, root)
+
+	// empty And matches every port, forcing all port files to be read
+	gfn := func(path string, res grep.Results, err error) error {
+		if err != nil && efn != nil {
+			return efn(path, err)
+		}
+		return err
+	}
+	err := grep.Search(context.Background(), grep.Options{
+		PortsRoot:  root,
+		Categories: categories,
+		Files:      files,
+		FileReader: x,
+		Expr:       grep.And(),
+		Flags:      flags,
+		Func:       gfn,
+		MaxJobs:    maxJobs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
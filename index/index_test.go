@@ -0,0 +1,210 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	root := writePorts(t, map[string]string{"devel/foo/Makefile": "PORTNAME=	foo\n"})
+	mk := filepath.Join(root, "devel/foo/Makefile")
+	path := filepath.Join(t.TempDir(), "ports.idx")
+
+	x, err := Build(path, root, nil, []string{"Makefile"}, 0, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 1 {
+		t.Fatalf("expected 1 indexed file, got %d", x.Len())
+	}
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err = Open(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 1 {
+		t.Fatalf("expected 1 indexed file after reopening, got %d", x.Len())
+	}
+
+	// index entry is used as long as the file is unchanged
+	x.entries["devel/foo/Makefile"].Data = []byte("PORTNAME=	cached\n")
+	data, err := x.ReadFile(mk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "PORTNAME=	cached\n" || x.Dirty() {
+		t.Errorf("expected index entry to be used, got %q", data)
+	}
+
+	// modified file invalidates its index entry
+	if err := os.WriteFile(mk, []byte("PORTNAME=	bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(mk, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	data, err = x.ReadFile(mk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "PORTNAME=	bar\n" || !x.Dirty() {
+		t.Errorf("expected stale index entry to be refreshed, got %q", data)
+	}
+
+	if _, err := x.ReadFile(filepath.Join(root, "devel/foo/pkg-plist")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing file, got %v", err)
+	}
+}
+
+func TestIndexDirs(t *testing.T) {
+	root := writePorts(t, map[string]string{"devel/foo/": ""})
+	path := filepath.Join(t.TempDir(), "ports.idx")
+
+	x, err := Build(path, root, nil, []string{"Makefile"}, 0, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+	x, err = Open(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cached listing is used as long as the directory is unchanged
+	x.dirs["devel"].Names = []string{"cached"}
+	names, err := x.ReadDirs(filepath.Join(root, "devel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "cached" || x.Dirty() {
+		t.Errorf("expected cached listing to be used, got %v", names)
+	}
+
+	// added port invalidates the listing
+	if err := os.Mkdir(filepath.Join(root, "devel/bar"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "devel"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	names, err = x.ReadDirs(filepath.Join(root, "devel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "bar" || names[1] != "foo" || !x.Dirty() {
+		t.Errorf("expected stale listing to be refreshed, got %v", names)
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.idx")
+	if err := os.WriteFile(path, []byte("\x1f\x8b\x08"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := Open(path, t.TempDir())
+	if err != nil {
+		t.Fatalf("expected corrupt index to be ignored, got %v", err)
+	}
+	if x.Len() != 0 || !x.Dirty() {
+		t.Errorf("expected empty index to be rebuilt, got %d files", x.Len())
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	a, err := DefaultPath("/usr/ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DefaultPath("/usr_ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("expected distinct index paths, got %s", a)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile": "PORTNAME=	foo\n",
+		"devel/loop/":        "",
+	})
+	path := filepath.Join(t.TempDir(), "ports.idx")
+	if err := os.Symlink("Makefile", filepath.Join(root, "devel/loop/Makefile")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(path, root, nil, []string{"Makefile"}, 0, nil, 1); err == nil {
+		t.Error("expected Build to fail without error func")
+	}
+
+	var failed []string
+	efn := func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}
+	x, err := Build(path, root, nil, []string{"Makefile"}, 0, efn, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0] != filepath.Join(root, "devel/loop") {
+		t.Errorf("expected devel/loop to fail, got %v", failed)
+	}
+	if x.Len() != 1 {
+		t.Errorf("expected 1 indexed file, got %d", x.Len())
+	}
+}
+
+func TestSavePrune(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"devel/foo/Makefile":  "PORTNAME=	foo\n",
+		"devel/bar/Makefile":  "PORTNAME=	foo\n",
+		"devel/bar/pkg-plist": "PORTNAME=	foo\n",
+	})
+	path := filepath.Join(t.TempDir(), "ports.idx")
+
+	x, err := Build(path, root, nil, []string{"Makefile", "pkg-plist"}, 0, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted port and file are dropped on the next save, even if they
+	// aren't read
+	if err := os.RemoveAll(filepath.Join(root, "devel/foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "devel/bar/pkg-plist")); err != nil {
+		t.Fatal(err)
+	}
+	x, err = Open(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.ReadFile(filepath.Join(root, "devel/bar/Makefile")); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err = Open(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := x.entries["devel/bar/Makefile"]; !ok || x.Len() != 1 {
+		t.Errorf("expected only devel/bar/Makefile to be left, got %d files", x.Len())
+	}
+}
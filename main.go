@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"html/template"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/dmgk/getopt"
+	"github.com/dmgk/portgrep/depends"
 	"github.com/dmgk/portgrep/formatter"
 	"github.com/dmgk/portgrep/grep"
+	"github.com/dmgk/portgrep/index"
+	"github.com/dmgk/portgrep/makefile"
 	"github.com/mattn/go-isatty"
 )
 
@@ -29,18 +37,51 @@ General options:
 
 Search options:
   -c name,... limit search to only these categories
+  -P file,... search these port files, glob patterns are allowed (default: {{.files}})
+  -L          follow .include directives and MASTERDIR of slave ports
+  -x          also search variable assignments with ${VAR} references expanded
   -O          multiple searches are OR-ed (default: AND-ed)
+  -D origin   list ports depending on origin (without @flavor), grouped by
+              dependency kind; takes no query
+  -Z          with -D, also list ports depending on origin indirectly
+  -Q format   output dependency graph of matching ports, or of all ports if
+              there's no query: [dot|json]
+  -e expr     search by boolean expression, e.g. "u:go & !X & (m:ports@ | m:me@)"
+  -v          select ports that do not match
+  -U          select only matches outside of .if conditionals
+  -i regex    select only matches inside of .if conditionals matching regex
   -F          interpret query as a plain text, not regular expression
+  -g          report all matches in a Makefile, not only the first one
   -j jobs     number of parallel jobs (default: {{.maxJobs}})
+  -k          keep going after errors reading ports, report them as warnings
+              and their number at the end, and exit with status 2
+  -I mode     search index mode: [build|use]; build (re)creates the index of
+              port files in $XDG_CACHE_HOME/portgrep, use searches the index
 
 Formatting options:
   -1          output origins in a single line (implies -o)
   -A count    show count lines of context after match
   -B count    show count lines of context before match
   -C count    show count lines of context around match
+  -W          show the whole block of assignments around match
+  -f format   output format: [text|json|ndjson|quickfix] (default: {{.outputFormat}})
   -o          output origins only
   -s          sort results by origin
   -T          do not indent results
+  -z          output origins of flavored ports as origin@flavor, one per flavor
+  -E          output only origin<TAB>value per result submatch (implies -g)
+  -y          output only distinct result submatch values with their counts,
+              most frequent first (implies -E)
+  -S group    output the number of matching ports grouped by group:
+              [category|maintainer|value]; group:N outputs only the top N
+              groups; value implies -g
+  -Y          with -S, also output results; with -f json, results and summary
+              are output as a single {"ports":[...],"summary":{...}} object
+  -N          output only the number of matching ports
+  -H          output only origin:count per port, count is the number of matches
+              (implies -g)
+  -q          output nothing, stop at the first match; exit status is 0 if any
+              port matched, 1 if none did, and 2 on errors
 
 Predefined searches:{{range .patterns}}
   {{.Description}}{{end}}
@@ -53,14 +94,46 @@ var (
 	colorMode         = "auto"
 	colors            = formatter.DefaultColors
 	categories        []string
+	files             = grep.DefaultFiles
+	followIncludes    bool
+	expandVars        bool
 	ored              bool
+	sorted            bool
+	invert            bool
 	plainText         bool
+	allMatches        bool
 	maxJobs           = runtime.NumCPU()
+	indexMode         string
+	dependsOrigin     string
+	transitive        bool
+	graphFormat       string
+	unconditional     bool
+	conditionRe       *regexp.Regexp
 	originsSingleLine bool
 	contextAfter      int
 	contextBefore     int
+	contextBlock      bool
 	originsOnly       bool
+	listFlavors       bool
+	extract           bool
+	uniqueValues      bool
+	summaryGroup      string
+	summaryLimit      int
+	summaryResults    bool
+	countPorts        bool
+	countResults      bool
+	quiet             bool
+	keepGoing         bool
+	failed            = map[string]bool{} // ports that weren't searched because of errors
 	noIndent          bool
+	outputFormat      = "text"
+)
+
+// Exit statuses, like grep(1).
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
 )
 
 const (
@@ -69,13 +142,38 @@ const (
 	colorModeNever  = "never"
 )
 
+const (
+	indexModeBuild = "build"
+	indexModeUse   = "use"
+)
+
+const (
+	graphFormatDOT  = "dot"
+	graphFormatJSON = "json"
+)
+
+const (
+	summaryCategory   = "category"
+	summaryMaintainer = "maintainer"
+	summaryValue      = "value"
+)
+
+const (
+	outputFormatText     = "text"
+	outputFormatJSON     = "json"
+	outputFormatNDJSON   = "ndjson"
+	outputFormatQuickfix = "quickfix"
+)
+
 func showUsage() {
 	err := usageTmpl.Execute(os.Stdout, map[string]interface{}{
-		"progname":  progname,
-		"colorMode": colorMode,
-		"colors":    colors,
-		"maxJobs":   maxJobs,
-		"patterns":  grep.Patterns,
+		"progname":     progname,
+		"colorMode":    colorMode,
+		"colors":       colors,
+		"maxJobs":      maxJobs,
+		"files":        strings.Join(files, ","),
+		"outputFormat": outputFormat,
+		"patterns":     grep.Patterns,
 	})
 	if err != nil {
 		panic(fmt.Sprintf("error executing template %s: %v", usageTmpl.Name(), err))
@@ -90,7 +188,49 @@ func errExit(format string, v ...interface{}) {
 	fmt.Fprint(os.Stderr, progname, ": ")
 	fmt.Fprintf(os.Stderr, format, v...)
 	fmt.Fprintln(os.Stderr)
-	os.Exit(1)
+	os.Exit(exitError)
+}
+
+// warn prints a warning message to stderr.
+func warn(format string, v ...interface{}) {
+	fmt.Fprint(os.Stderr, progname, ": ")
+	fmt.Fprintf(os.Stderr, format, v...)
+	fmt.Fprintln(os.Stderr)
+}
+
+// searchError returns err of searching path, to abort the search.  If -k is
+// set, err is printed as a warning instead, once per path, and the search
+// continues.
+func searchError(path string, err error) error {
+	if !keepGoing {
+		return err
+	}
+	if !failed[path] {
+		warn("%s: %s", path, err)
+		failed[path] = true
+	}
+	return nil
+}
+
+// exit exits with exitMatch status if something matched, or with
+// exitNoMatch otherwise.  If errors were ignored by -k, it reports the number
+// of ports that couldn't be searched and exits with exitError, unless -q is
+// set and something matched, like grep(1) does.
+func exit(matched bool) {
+	if n := len(failed); n > 0 {
+		if n == 1 {
+			warn("1 port could not be searched")
+		} else {
+			warn("%d ports could not be searched", n)
+		}
+	}
+	if len(failed) > 0 && !(quiet && matched) {
+		os.Exit(exitError)
+	}
+	if matched {
+		os.Exit(exitMatch)
+	}
+	os.Exit(exitNoMatch)
 }
 
 func main() {
@@ -105,13 +245,14 @@ func main() {
 		colors = v
 	}
 
-	opts, err := getopt.NewArgv("hVR:M:G:c:OFj:1A:B:C:osT"+grep.Patterns.OptionString(), argsWithDefaults(os.Args, "PORTGREP_OPTS"))
+	opts, err := getopt.NewArgv("hVR:M:G:c:P:LxOD:ZQ:e:vUi:Fgj:I:1A:B:C:Wf:osTzEyS:YNHqk"+grep.Patterns.OptionString(), argsWithDefaults(os.Args, "PORTGREP_OPTS"))
 	if err != nil {
 		panic(fmt.Sprintf("error creating options parser: %s", err))
 	}
 	progname = opts.ProgramName()
 
 	var pts []grep.Pattern
+	var exprs []string
 
 	for opts.Scan() {
 		opt, err := opts.Option()
@@ -139,10 +280,44 @@ func main() {
 			colors = opt.String()
 		case 'c':
 			categories = splitOptions(opt.String())
+		case 'P':
+			files = splitOptions(opt.String())
+		case 'L':
+			followIncludes = true
+		case 'x':
+			expandVars = true
 		case 'O':
 			ored = true
+		case 'D':
+			dependsOrigin = strings.Trim(opt.String(), "/")
+			if strings.Contains(dependsOrigin, "@") {
+				errExit("-D: flavored origins are not supported: %s", dependsOrigin)
+			}
+		case 'Z':
+			transitive = true
+		case 'Q':
+			switch opt.String() {
+			case graphFormatDOT, graphFormatJSON:
+				graphFormat = opt.String()
+			default:
+				errExit("-Q: invalid graph format: %s", opt.String())
+			}
+		case 'e':
+			exprs = append(exprs, opt.String())
+		case 'v':
+			invert = true
+		case 'U':
+			unconditional = true
+		case 'i':
+			re, err := regexp.Compile(opt.String())
+			if err != nil {
+				errExit("-i: %s", err)
+			}
+			conditionRe = re
 		case 'F':
 			plainText = true
+		case 'g':
+			allMatches = true
 		case 'j':
 			v, err := opt.Int()
 			if err != nil {
@@ -152,6 +327,13 @@ func main() {
 				v = 1
 			}
 			maxJobs = v
+		case 'I':
+			switch opt.String() {
+			case indexModeBuild, indexModeUse:
+				indexMode = opt.String()
+			default:
+				errExit("-I: invalid index mode: %s", opt.String())
+			}
 		case '1':
 			originsSingleLine = true
 		case 'A':
@@ -159,26 +341,79 @@ func main() {
 			if err != nil {
 				errExit("-A: %s", err)
 			}
+			if v < 0 {
+				errExit("-A: invalid count")
+			}
 			contextAfter = v
 		case 'B':
 			v, err := opt.Int()
 			if err != nil {
 				errExit("-B: %s", err)
 			}
+			if v < 0 {
+				errExit("-B: invalid count")
+			}
 			contextBefore = v
 		case 'C':
 			v, err := opt.Int()
 			if err != nil {
 				errExit("-C: %s", err)
 			}
+			if v < 0 {
+				errExit("-C: invalid count")
+			}
 			contextBefore = v
 			contextAfter = v
+		case 'W':
+			contextBlock = true
+		case 'f':
+			switch opt.String() {
+			case outputFormatText, outputFormatJSON, outputFormatNDJSON, outputFormatQuickfix:
+				outputFormat = opt.String()
+			default:
+				errExit("-f: invalid output format: %s", opt.String())
+			}
 		case 'o':
 			originsOnly = true
 		case 's':
-			maxJobs = 1
+			sorted = true
 		case 'T':
 			noIndent = true
+		case 'z':
+			listFlavors = true
+		case 'E':
+			extract = true
+		case 'y':
+			extract = true
+			uniqueValues = true
+		case 'S':
+			group, limit := opt.String(), ""
+			if i := strings.IndexByte(group, ':'); i >= 0 {
+				group, limit = group[:i], group[i+1:]
+			}
+			switch group {
+			case summaryCategory, summaryMaintainer, summaryValue:
+				summaryGroup = group
+			default:
+				errExit("-S: invalid summary group: %s", group)
+			}
+			if limit != "" {
+				v, err := strconv.Atoi(limit)
+				if err != nil || v < 0 {
+					errExit("-S: invalid limit: %s", limit)
+				}
+				summaryLimit = v
+			}
+		case 'Y':
+			summaryResults = true
+		case 'N':
+			countPorts = true
+		case 'H':
+			countResults = true
+		case 'q':
+			quiet = true
+		case 'k':
+			keepGoing = true
 		default:
 			p := grep.Patterns.Get(opt.Opt, opt.String())
 			if p == nil {
@@ -188,42 +423,365 @@ func main() {
 		}
 	}
 
-	var rxs []*grep.Regexp
+	// extracted values and counts are always output as text
+	if outputFormat != outputFormatText {
+		switch {
+		case uniqueValues:
+			errExit("-y: unsupported output format: %s", outputFormat)
+		case extract:
+			errExit("-E: unsupported output format: %s", outputFormat)
+		case countPorts:
+			errExit("-N: unsupported output format: %s", outputFormat)
+		case countResults:
+			errExit("-H: unsupported output format: %s", outputFormat)
+		case summaryGroup != "" && outputFormat == outputFormatQuickfix:
+			errExit("-S: unsupported output format: %s", outputFormat)
+		}
+	}
+
+	if extract || countResults || summaryGroup == summaryValue {
+		allMatches = true
+	}
+	if contextBlock {
+		contextBefore = grep.ContextBlock
+		contextAfter = grep.ContextBlock
+	}
+
+	var gflags int
+	if sorted {
+		gflags |= grep.Gsorted
+	}
+	if allMatches {
+		gflags |= grep.GallMatches
+	}
+	if invert {
+		gflags |= grep.Ginvert
+	}
+	if followIncludes {
+		gflags |= grep.GfollowIncludes
+	}
+	if expandVars {
+		gflags |= grep.GexpandVars
+	}
+
+	if dependsOrigin != "" && (len(pts) > 0 || len(exprs) > 0 || len(opts.Args()) > 0) {
+		errExit("-D: doesn't take a query")
+	}
+
+	var idx *index.Index
+	if indexMode != "" {
+		path, err := index.DefaultPath(portsRoot)
+		if err != nil {
+			errExit("-I: %s", err)
+		}
+		if indexMode == indexModeBuild {
+			if len(pts) > 0 || len(exprs) > 0 || len(opts.Args()) > 0 {
+				errExit("-I: %s doesn't take a query", indexModeBuild)
+			}
+			idx, err := index.Build(path, portsRoot, categories, files, gflags&(grep.GfollowIncludes|grep.GexpandVars), searchError, maxJobs)
+			if err != nil {
+				errExit("-I: %s", err)
+			}
+			if err := idx.Save(); err != nil {
+				errExit("-I: %s", err)
+			}
+			exit(true)
+		}
+		idx, err = index.Open(path, portsRoot)
+		if err != nil {
+			errExit("-I: %s", err)
+		}
+	}
+
+	var fr grep.FileReader
+	if idx != nil {
+		fr = idx
+	}
+
+	if dependsOrigin != "" {
+		matched := listDependents(fr)
+		saveIndex(idx)
+		exit(matched)
+	}
+
+	var terms []grep.Expr
 
 	for _, p := range pts {
 		rx, err := p.Compile(contextBefore, contextAfter, plainText)
 		if err != nil {
 			errExit("-%c: %s", p.Option(), err)
 		}
-		rxs = append(rxs, rx)
+		terms = append(terms, grep.Term(rx))
+	}
+	for _, e := range exprs {
+		x, err := grep.Patterns.ParseExpr(e, contextBefore, contextAfter, plainText)
+		if err != nil {
+			errExit("-e: %s", err)
+		}
+		terms = append(terms, x)
 	}
 	for _, q := range opts.Args() {
 		rx, err := grep.Compile(q, contextBefore, contextAfter, plainText)
 		if err != nil {
 			errExit("query %q: %s", q, err)
 		}
-		rxs = append(rxs, rx)
+		terms = append(terms, grep.Term(rx))
 	}
 
-	if len(rxs) == 0 {
+	if len(terms) == 0 && graphFormat == "" {
 		showUsage()
-		os.Exit(0)
+		os.Exit(exitError)
+	}
+
+	var expr grep.Expr
+	if len(terms) > 0 {
+		if ored {
+			expr = grep.Or(terms...)
+		} else {
+			expr = grep.And(terms...)
+		}
+	}
+
+	if expr != nil && (unconditional || conditionRe != nil) {
+		expr = grep.FilterConditions(expr, matchConditions)
+	}
+
+	if quiet {
+		matched := findMatch(fr, expr, gflags)
+		saveIndex(idx)
+		exit(matched)
+	}
+
+	if graphFormat != "" {
+		matched := writeGraph(fr, expr, gflags)
+		saveIndex(idx)
+		exit(matched)
+	}
+
+	if countPorts || countResults {
+		matched := countMatches(fr, expr, gflags)
+		saveIndex(idx)
+		exit(matched)
 	}
 
 	f := initFormatter()
+	if summaryGroup != "" {
+		f = initSummary(f, fr)
+	}
+	if err := f.Begin(); err != nil {
+		errExit(err.Error())
+	}
+	matched := false
 	gfn := func(path string, results grep.Results, err error) error {
 		if err != nil {
-			return err
+			return searchError(path, err)
 		}
+		matched = true
 		return f.Format(path, results)
 	}
-	if err := grep.Grep(portsRoot, categories, rxs, ored, gfn, maxJobs); err != nil {
+	if err := grep.Search(context.Background(), grep.Options{
+		PortsRoot:  portsRoot,
+		Categories: categories,
+		Files:      files,
+		FileReader: fr,
+		Expr:       expr,
+		Flags:      gflags,
+		Func:       gfn,
+		MaxJobs:    maxJobs,
+	}); err != nil {
 		errExit(err.Error())
 	}
+	if err := f.End(); err != nil {
+		errExit(err.Error())
+	}
+	saveIndex(idx)
+	exit(matched)
 }
 
-func initFormatter() formatter.Formatter {
-	var w io.Writer = os.Stdout
+// findMatch reports whether any port matches expr, it stops the search at
+// the first match.
+func findMatch(fr grep.FileReader, expr grep.Expr, gflags int) bool {
+	matched := false
+	gfn := func(path string, results grep.Results, err error) error {
+		if err != nil {
+			return searchError(path, err)
+		}
+		matched = true
+		return grep.Stop
+	}
+	if err := grep.Search(context.Background(), grep.Options{
+		PortsRoot:  portsRoot,
+		Categories: categories,
+		Files:      files,
+		FileReader: fr,
+		Expr:       expr,
+		Flags:      gflags &^ grep.Gsorted,
+		Func:       gfn,
+		MaxJobs:    maxJobs,
+	}); err != nil {
+		errExit(err.Error())
+	}
+	return matched
+}
+
+// matchConditions reports whether a match inside conditionals conds is
+// selected by -U and -i options.
+func matchConditions(conds []string) bool {
+	if unconditional && len(conds) > 0 {
+		return false
+	}
+	if conditionRe != nil {
+		for _, c := range conds {
+			if conditionRe.MatchString(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// saveIndex saves idx if it's been updated, to refresh stale index entries
+// for the next search.
+func saveIndex(idx *index.Index) {
+	if idx != nil && idx.Dirty() {
+		if err := idx.Save(); err != nil {
+			errExit("-I: %s", err)
+		}
+	}
+}
+
+func writeGraph(fr grep.FileReader, expr grep.Expr, gflags int) bool {
+	g, err := depends.Build(portsRoot, categories, fr, searchError, maxJobs)
+	if err != nil {
+		errExit("-Q: %s", err)
+	}
+
+	origins := g.Ports()
+	if expr != nil {
+		origins = nil
+		gfn := func(path string, results grep.Results, err error) error {
+			if err != nil {
+				return searchError(path, err)
+			}
+			origin, err := filepath.Rel(portsRoot, path)
+			if err != nil {
+				return err
+			}
+			origins = append(origins, filepath.ToSlash(origin))
+			return nil
+		}
+		if err := grep.Search(context.Background(), grep.Options{
+			PortsRoot:  portsRoot,
+			Categories: categories,
+			Files:      files,
+			FileReader: fr,
+			Expr:       expr,
+			Flags:      gflags | grep.Gsorted,
+			Func:       gfn,
+			MaxJobs:    maxJobs,
+		}); err != nil {
+			errExit(err.Error())
+		}
+	}
+
+	if graphFormat == graphFormatDOT {
+		err = formatter.WriteGraphDOT(os.Stdout, g, origins)
+	} else {
+		err = formatter.WriteGraphJSON(os.Stdout, g, origins)
+	}
+	if err != nil {
+		errExit(err.Error())
+	}
+	return len(origins) > 0
+}
+
+// countMatches writes the number of matching ports, or origin:count line per
+// port with the number of its matches if -H is set.  It reports whether any
+// port matched.
+func countMatches(fr grep.FileReader, expr grep.Expr, gflags int) bool {
+	w := bufio.NewWriter(os.Stdout)
+	ports := 0
+	gfn := func(path string, results grep.Results, err error) error {
+		if err != nil {
+			return searchError(path, err)
+		}
+		ports++
+		if countResults {
+			origin, err := filepath.Rel(portsRoot, path)
+			if err != nil {
+				return err
+			}
+			matches := 0
+			for _, m := range results {
+				matches += len(m.ResultSubmatch) / 2
+			}
+			fmt.Fprintf(w, "%s:%d\n", filepath.ToSlash(origin), matches)
+		}
+		return nil
+	}
+	if err := grep.Search(context.Background(), grep.Options{
+		PortsRoot:  portsRoot,
+		Categories: categories,
+		Files:      files,
+		FileReader: fr,
+		Expr:       expr,
+		Flags:      gflags,
+		Func:       gfn,
+		MaxJobs:    maxJobs,
+	}); err != nil {
+		errExit(err.Error())
+	}
+	if countPorts {
+		fmt.Fprintln(w, ports)
+	}
+	if err := w.Flush(); err != nil {
+		errExit(err.Error())
+	}
+	return ports > 0
+}
+
+func listDependents(fr grep.FileReader) bool {
+	if quiet {
+		// port has transitive dependents only if it has direct ones
+		matched, err := depends.HasDependents(portsRoot, categories, fr, dependsOrigin, searchError, maxJobs)
+		if err != nil {
+			errExit("-D: %s", err)
+		}
+		return matched
+	}
+
+	g, err := depends.Build(portsRoot, categories, fr, searchError, maxJobs)
+	if err != nil {
+		errExit("-D: %s", err)
+	}
+
+	var deps map[string][]string
+	if transitive {
+		deps = g.TransitiveDependents(dependsOrigin)
+	} else {
+		deps = g.Dependents(dependsOrigin)
+	}
+
+	switch outputFormat {
+	case outputFormatJSON, outputFormatNDJSON:
+		err = formatter.WriteDependentsJSON(os.Stdout, dependsOrigin, deps)
+	case outputFormatText:
+		indent := "\t"
+		if noIndent {
+			indent = ""
+		}
+		err = formatter.WriteDependents(os.Stdout, deps, indent, formatterFlags())
+	default:
+		errExit("-D: unsupported output format: %s", outputFormat)
+	}
+	if err != nil {
+		errExit(err.Error())
+	}
+	return len(deps) > 0
+}
+
+func formatterFlags() int {
 	flags := formatter.Fdefaults
 	term := isatty.IsTerminal(os.Stdout.Fd())
 
@@ -239,6 +797,31 @@ func initFormatter() formatter.Formatter {
 	if originsOnly {
 		flags |= formatter.ForiginsOnly
 	}
+	if listFlavors {
+		flags |= formatter.Fflavors
+	}
+	if followIncludes || len(files) != 1 || files[0] != "Makefile" {
+		flags |= formatter.FfileNames
+	}
+	return flags
+}
+
+func initFormatter() formatter.Formatter {
+	var w io.Writer = os.Stdout
+	flags := formatterFlags()
+
+	switch outputFormat {
+	case outputFormatJSON:
+		return formatter.NewJSON(w, portsRoot, flags)
+	case outputFormatNDJSON:
+		return formatter.NewNDJSON(w, portsRoot, flags)
+	case outputFormatQuickfix:
+		return formatter.NewQuickfix(w)
+	}
+
+	if extract {
+		return formatter.NewExtract(w, portsRoot, flags, uniqueValues)
+	}
 
 	f := formatter.NewText(w, portsRoot, flags)
 	if !noIndent {
@@ -247,6 +830,46 @@ func initFormatter() formatter.Formatter {
 	return f
 }
 
+// initSummary returns the summary formatter of -S option, f is used to format
+// results too if -Y is set.
+func initSummary(f formatter.Formatter, fr grep.FileReader) formatter.Formatter {
+	var key formatter.SummaryFunc
+	switch summaryGroup {
+	case summaryCategory:
+		key = formatter.CategorySummary
+	case summaryMaintainer:
+		key = func(origin string, results grep.Results) ([]string, error) {
+			return portMaintainer(origin, fr)
+		}
+	case summaryValue:
+		key = formatter.ValueSummary
+	}
+
+	if !summaryResults {
+		f = nil
+	}
+	asJSON := outputFormat == outputFormatJSON || outputFormat == outputFormatNDJSON
+	return formatter.NewSummary(os.Stdout, portsRoot, formatterFlags(), summaryGroup, key, summaryLimit, asJSON, f)
+}
+
+// portMaintainer returns MAINTAINER of the port origin, following master
+// ports and included files.
+func portMaintainer(origin string, fr grep.FileReader) ([]string, error) {
+	texts, err := grep.ReadPort(portsRoot, filepath.Join(portsRoot, origin), grep.DefaultFiles, fr, grep.GfollowIncludes)
+	if err != nil {
+		return nil, err
+	}
+	maintainer := "(none)"
+	for _, t := range texts {
+		for _, tok := range t.Tokens() {
+			if tok.Type == makefile.Tassignment && tok.Name == "MAINTAINER" && tok.Value != "" {
+				maintainer = tok.Value
+			}
+		}
+	}
+	return []string{maintainer}, nil
+}
+
 func argsWithDefaults(argv []string, env string) []string {
 	args := argv[1:]
 	if v, ok := os.LookupEnv(env); ok && v != "" {
@@ -0,0 +1,136 @@
+package makefile
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Dependency kinds, in the order ports framework resolves them.
+const (
+	KindFetch   = "fetch"
+	KindExtract = "extract"
+	KindPatch   = "patch"
+	KindBuild   = "build"
+	KindLib     = "lib"
+	KindRun     = "run"
+	KindTest    = "test"
+	KindPkg     = "pkg"
+)
+
+// Kinds lists all dependency kinds.
+var Kinds = []string{KindFetch, KindExtract, KindPatch, KindBuild, KindLib, KindRun, KindTest, KindPkg}
+
+var dependsVarRe = regexp.MustCompile(`^(?:\w+?_)?(FETCH|EXTRACT|PATCH|BUILD|LIB|RUN|TEST|PKG)_DEPENDS$`)
+
+// Dependency describes one target:origin[@flavor][:target] tuple of a
+// *_DEPENDS variable.
+type Dependency struct {
+	// Kind is the dependency kind, one of Kinds
+	Kind string
+	// Var is the name of the variable declaring the dependency, like
+	// "LIB_DEPENDS" or option helper "X11_LIB_DEPENDS"
+	Var string
+	// Target is the file, library or package name satisfying the dependency
+	Target string
+	// Origin is the origin of the port providing the dependency, without
+	// flavor
+	Origin string
+	// Flavor is the flavor of the dependency port, if any
+	Flavor string
+	// Line is the physical line number of the assignment declaring the
+	// dependency
+	Line int
+}
+
+// DependsKind returns dependency kind declared by the variable name, or "" if
+// name is not a *_DEPENDS variable.
+func DependsKind(name string) string {
+	m := dependsVarRe.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// ParseDependencies returns dependencies declared by *_DEPENDS assignments
+// in as.  Variable references in assignment values are expanded using vars,
+// which should have all port assignments applied.  Dependency origins are
+// relative to the ports tree root, "${PORTSDIR}/" prefix is stripped.
+func ParseDependencies(as []*Assignment, vars *Vars) []*Dependency {
+	var res []*Dependency
+
+	for _, a := range as {
+		name := vars.Expand(a.Name)
+		kind := DependsKind(name)
+		if kind == "" || a.Op == "!=" {
+			continue
+		}
+
+		for _, w := range fields(vars.Expand(a.Value)) {
+			parts := splitOutside(w, ':')
+			if len(parts) < 2 || parts[1] == "" {
+				continue
+			}
+
+			d := &Dependency{
+				Kind:   kind,
+				Var:    name,
+				Target: parts[0],
+				Origin: strings.TrimPrefix(parts[1], "${PORTSDIR}/"),
+				Line:   a.Line,
+			}
+			if i := strings.LastIndexByte(d.Origin, '@'); i >= 0 {
+				d.Origin, d.Flavor = d.Origin[:i], d.Origin[i+1:]
+			}
+			d.Origin = strings.TrimSuffix(d.Origin, "/")
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
+
+// fields splits s around whitespace outside of variable references.
+func fields(s string) []string {
+	var res []string
+	start, depth := -1, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t'):
+			if start >= 0 {
+				res = append(res, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		res = append(res, s[start:])
+	}
+	return res
+}
+
+// splitOutside splits s around sep outside of variable references.
+func splitOutside(s string, sep byte) []string {
+	var res []string
+	start, depth := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			depth--
+		case depth == 0 && c == sep:
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, s[start:])
+}
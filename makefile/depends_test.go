@@ -0,0 +1,41 @@
+package makefile
+
+import (
+	"testing"
+)
+
+func TestParseDependencies(t *testing.T) {
+	as := ParseAssignments([]byte(`PORTNAME=	foo
+BUILD_DEPENDS=	bar>0:devel/bar \
+		${LOCALBASE}/bin/baz:${PORTSDIR}/devel/baz
+LIB_DEPENDS=	lib${PORTNAME}.so:devel/lib${PORTNAME}
+RUN_DEPENDS=	${PYTHON_PKGNAMEPREFIX}six>0:devel/py-six@${PY_FLAVOR} \
+		qux:devel/qux:patch
+X11_LIB_DEPENDS=	libX11.so:x11/libX11
+FETCH_DEPENDS=	invalid
+MY_DEPENDS=	not:a/dependency
+`))
+	v := NewVars()
+	for _, a := range as {
+		v.Apply(a)
+	}
+
+	expected := []Dependency{
+		{KindBuild, "BUILD_DEPENDS", "bar>0", "devel/bar", "", 2},
+		{KindBuild, "BUILD_DEPENDS", "${LOCALBASE}/bin/baz", "devel/baz", "", 2},
+		{KindLib, "LIB_DEPENDS", "libfoo.so", "devel/libfoo", "", 4},
+		{KindRun, "RUN_DEPENDS", "${PYTHON_PKGNAMEPREFIX}six>0", "devel/py-six", "${PY_FLAVOR}", 5},
+		{KindRun, "RUN_DEPENDS", "qux", "devel/qux", "", 5},
+		{KindLib, "X11_LIB_DEPENDS", "libX11.so", "x11/libX11", "", 7},
+	}
+
+	ds := ParseDependencies(as, v)
+	if len(ds) != len(expected) {
+		t.Fatalf("expected %d dependencies, got %d: %v", len(expected), len(ds), ds)
+	}
+	for i, d := range ds {
+		if *d != expected[i] {
+			t.Errorf("[#%d] expected %+v, got %+v", i, expected[i], *d)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package makefile implements parsing of port Makefiles and expansion of make
+// variables, without running make(1).
+package makefile
+
+import (
+	"strings"
+)
+
+// Assignment describes one variable assignment.
+type Assignment struct {
+	// Name is the variable name, with variable references in it expanded
+	// at the time of assignment
+	Name string
+	// Op is the assignment operator, one of "=", "+=", "?=", ":=" or "!="
+	Op string
+	// Value is the assigned value, with continuation lines joined and
+	// comments stripped
+	Value string
+	// Line is the physical line number (starting from 1) the assignment
+	// starts on
+	Line int
+}
+
+// ParseAssignments returns all variable assignments in the Makefile text b,
+// in the order of appearance.  Conditional directives are not evaluated,
+// assignments in all branches are returned.
+func ParseAssignments(b []byte) []*Assignment {
+	var res []*Assignment
+
+	for _, tok := range Tokenize(b) {
+		if tok.Type == Tassignment {
+			res = append(res, &Assignment{
+				Name:  tok.Name,
+				Op:    tok.Op,
+				Value: tok.Value,
+				Line:  tok.Line,
+			})
+		}
+	}
+
+	return res
+}
+
+// stripComment removes a trailing comment from s, "\#" is an escaped "#".
+func stripComment(s string) string {
+	if strings.IndexByte(s, '#') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '#' {
+			b.WriteByte('#')
+			i++
+			continue
+		}
+		if s[i] == '#' {
+			break
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
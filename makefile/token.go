@@ -0,0 +1,391 @@
+package makefile
+
+import (
+	"strings"
+)
+
+// TokenType is the type of a Makefile token.
+type TokenType int
+
+const (
+	// Tcomment is a comment line
+	Tcomment TokenType = iota
+	// Tassignment is a variable assignment
+	Tassignment
+	// Tconditional is a conditional directive, like .if or .endif
+	Tconditional
+	// Tinclude is an .include directive, or one of its variants
+	Tinclude
+	// Tdirective is any other directive, like .for or .error
+	Tdirective
+	// Ttarget is a dependency line, declaring a target
+	Ttarget
+	// Trecipe is a shell command line of a target
+	Trecipe
+)
+
+var tokenTypes = [...]string{"comment", "assignment", "conditional", "include", "directive", "target", "recipe"}
+
+func (t TokenType) String() string {
+	if int(t) < len(tokenTypes) {
+		return tokenTypes[t]
+	}
+	return "unknown"
+}
+
+// Token is one logical line of a Makefile.  Logical lines span physical lines
+// joined by backslash-newline continuations.
+type Token struct {
+	Type TokenType
+	// Offset and End are byte offsets of the token start and end in the
+	// Makefile, End excludes the terminating newline
+	Offset int
+	End    int
+	// Line is the physical line number (starting from 1) the token starts on
+	Line int
+	// Name is the variable name of Tassignment, directive name (like "if",
+	// "endfor" or "include") of Tconditional, Tinclude and Tdirective, and
+	// target names of Ttarget
+	Name string
+	// NameOffset is the byte offset of Name in the Makefile, for assignments
+	// and targets
+	NameOffset int
+	// Op is the assignment operator of Tassignment, or the dependency
+	// operator (":", "::" or "!") of Ttarget
+	Op string
+	// Value is the value of Tassignment, arguments of directives, the
+	// included file of Tinclude, sources of Ttarget, text of Tcomment, or the
+	// command of Trecipe.  Continuation lines are joined with a single space,
+	// trailing comments are stripped.
+	Value string
+	// ValueOffset and ValueEnd are byte offsets of the raw value in the
+	// Makefile, for assignments
+	ValueOffset int
+	ValueEnd    int
+	// Words are whitespace separated words of the assignment value, variable
+	// references are never split
+	Words []Word
+}
+
+// Word is one word of an assignment value.
+type Word struct {
+	Text string
+	// Offset and End are byte offsets of the word in the Makefile
+	Offset int
+	End    int
+}
+
+var (
+	conditionals = map[string]struct{}{
+		"if": {}, "ifdef": {}, "ifndef": {}, "ifmake": {}, "ifnmake": {},
+		"elif": {}, "elifdef": {}, "elifndef": {}, "elifmake": {}, "elifnmake": {},
+		"else": {}, "endif": {},
+	}
+	includes = map[string]struct{}{
+		"include": {}, "sinclude": {}, "-include": {}, "dinclude": {},
+	}
+	directives = map[string]struct{}{
+		"for": {}, "endfor": {}, "undef": {}, "export": {}, "export-env": {},
+		"export-literal": {}, "unexport": {}, "unexport-env": {}, "error": {},
+		"warning": {}, "info": {}, "break": {},
+	}
+)
+
+// Tokenize splits the Makefile text b into tokens, in the order of
+// appearance.  Blank lines, and lines that can't be parsed, produce no
+// tokens.
+func Tokenize(b []byte) []*Token {
+	var res []*Token
+
+	inRule := false
+	line := 1
+	for off := 0; off < len(b); {
+		end, lines := logicalEnd(b, off)
+		raw := string(b[off:end])
+
+		if tok := parseToken(raw, inRule); tok != nil {
+			tok.Offset += off
+			tok.End = end
+			tok.Line = line
+			tok.NameOffset += off
+			tok.ValueOffset += off
+			tok.ValueEnd += off
+			for i := range tok.Words {
+				tok.Words[i].Offset += off
+				tok.Words[i].End += off
+			}
+			res = append(res, tok)
+
+			switch tok.Type {
+			case Ttarget:
+				inRule = true
+			case Tassignment, Tinclude:
+				inRule = false
+			}
+		}
+
+		off = end + 1
+		line += lines
+	}
+
+	return res
+}
+
+// logicalEnd returns the end offset of the logical line starting at off, and
+// the number of physical lines it spans.
+func logicalEnd(b []byte, off int) (int, int) {
+	lines := 1
+	for i := off; i < len(b); i++ {
+		if b[i] != '\n' {
+			continue
+		}
+		if isContinued(b[off:i]) {
+			lines++
+			continue
+		}
+		return i, lines
+	}
+	return len(b), lines
+}
+
+// isContinued reports whether line ends with an unescaped backslash.
+func isContinued(line []byte) bool {
+	n := len(line)
+	return n > 0 && line[n-1] == '\\' && (n == 1 || line[n-2] != '\\')
+}
+
+// parseToken parses the logical line s, all offsets of the returned token
+// are relative to the start of s.
+func parseToken(s string, inRule bool) *Token {
+	trimmed := strings.TrimLeft(s, " \t")
+	switch {
+	case trimmed == "" || trimmed == "\\":
+		return nil
+	case s[0] == '\t' && inRule:
+		return &Token{Type: Trecipe, Value: joinLines(trimmed)}
+	case trimmed[0] == '#':
+		return &Token{Type: Tcomment, Value: joinLines(trimmed)}
+	case s[0] == '.':
+		if tok := parseDirective(s); tok != nil {
+			return tok
+		}
+	}
+
+	if tok := parseAssignment(s); tok != nil {
+		return tok
+	}
+	return parseTarget(s)
+}
+
+// parseDirective parses s as a directive line, like ".  if ${FOO}".
+func parseDirective(s string) *Token {
+	rest := strings.TrimLeft(s[1:], " \t")
+	i := 0
+	for i < len(rest) && (rest[i] >= 'a' && rest[i] <= 'z' || rest[i] == '-') {
+		i++
+	}
+	name, args := rest[:i], strings.TrimSpace(stripComment(joinLines(rest[i:])))
+
+	tok := &Token{Name: name, Value: args}
+	if _, ok := conditionals[name]; ok {
+		tok.Type = Tconditional
+	} else if _, ok := includes[name]; ok {
+		tok.Type = Tinclude
+		if len(args) > 1 && (args[0] == '"' || args[0] == '<') {
+			tok.Value = strings.TrimRight(args[1:], "\">")
+		}
+	} else if _, ok := directives[name]; ok {
+		tok.Type = Tdirective
+	} else {
+		return nil // special target or assignment, like .PHONY:
+	}
+	return tok
+}
+
+// parseAssignment parses s as a variable assignment, it returns nil if s is
+// not an assignment.
+func parseAssignment(s string) *Token {
+	if s[0] == '\t' {
+		return nil
+	}
+
+	start := len(s) - len(strings.TrimLeft(s, " "))
+
+	// variable name ends at whitespace or an assignment operator, except
+	// inside of variable references
+	i, depth := start, 0
+loop:
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			depth--
+		case depth > 0:
+		case c == ' ' || c == '\t' || c == '\\' || c == '=' || strings.IndexByte("+?:!", c) >= 0 && i+1 < len(s) && s[i+1] == '=':
+			break loop
+		case c == ':' || c == '#':
+			return nil // dependency line or comment
+		}
+	}
+	name := s[start:i]
+	if name == "" {
+		return nil
+	}
+
+	j := i + len(s[i:]) - len(trimSpace(s[i:]))
+	var op string
+	switch {
+	case strings.HasPrefix(s[j:], "="):
+		op = "="
+	case j+1 < len(s) && s[j+1] == '=' && strings.IndexByte("+?:!", s[j]) >= 0:
+		op = s[j : j+2]
+	default:
+		return nil
+	}
+
+	// raw value spans from the first non-blank byte after the operator to
+	// the trailing comment, if any
+	vs := j + len(op)
+	vs += len(s[vs:]) - len(trimSpace(s[vs:]))
+	ve := vs + commentStart(s[vs:])
+	for ve > vs && isSpace(s[ve-1]) {
+		ve--
+	}
+
+	return &Token{
+		Type:        Tassignment,
+		Name:        name,
+		NameOffset:  start,
+		Op:          op,
+		Value:       strings.TrimSpace(stripComment(joinLines(s[vs:]))),
+		ValueOffset: vs,
+		ValueEnd:    ve,
+		Words:       words(s[vs:ve], vs),
+	}
+}
+
+// parseTarget parses s as a dependency line, it returns nil if s is not a
+// dependency line.
+func parseTarget(s string) *Token {
+	if s[0] == '\t' || s[0] == ' ' {
+		return nil
+	}
+
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			depth--
+		case depth > 0:
+		case c == ':' || c == '!':
+			op := s[i : i+1]
+			if strings.HasPrefix(s[i:], "::") {
+				op = "::"
+			}
+			return &Token{
+				Type:  Ttarget,
+				Name:  strings.TrimSpace(s[:i]),
+				Op:    op,
+				Value: strings.TrimSpace(stripComment(joinLines(s[i+len(op):]))),
+			}
+		case c == '#':
+			return nil
+		}
+	}
+	return nil
+}
+
+// joinLines joins continuation lines of s with a single space, like make
+// does.
+func joinLines(s string) string {
+	if strings.IndexByte(s, '\n') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for first := true; ; first = false {
+		i := strings.IndexByte(s, '\n')
+		l := s
+		if i >= 0 {
+			l = s[:i]
+		}
+		if !first {
+			l = strings.TrimLeft(l, " \t")
+		}
+		if i < 0 {
+			b.WriteString(l)
+			break
+		}
+		b.WriteString(strings.TrimRight(strings.TrimSuffix(l, "\\"), " \t"))
+		b.WriteByte(' ')
+		s = s[i+1:]
+	}
+	return b.String()
+}
+
+// words splits the raw value s into words, offset is the offset of s.
+func words(s string, offset int) []Word {
+	var res []Word
+	start, depth := -1, 0
+	for i := 0; i <= len(s); i++ {
+		sep := i == len(s)
+		if !sep {
+			switch c := s[i]; {
+			case c == '{' || c == '(':
+				depth++
+			case c == '}' || c == ')':
+				depth--
+			case depth == 0 && (isSpace(c) || c == '\\' && i+1 < len(s) && s[i+1] == '\n'):
+				sep = true
+			}
+		}
+		if sep {
+			if start >= 0 {
+				res = append(res, Word{s[start:i], offset + start, offset + i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	return res
+}
+
+// commentStart returns the index of the unescaped "#" starting a comment in
+// s, or len(s).
+func commentStart(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			continue
+		}
+		if s[i] == '#' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// trimSpace trims leading whitespace and continuations from s.
+func trimSpace(s string) string {
+	for len(s) > 0 {
+		switch {
+		case isSpace(s[0]):
+			s = s[1:]
+		case strings.HasPrefix(s, "\\\n"):
+			s = s[2:]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
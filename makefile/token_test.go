@@ -0,0 +1,79 @@
+package makefile
+
+import (
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	src := `# comment
+PORTNAME=	foo
+USES	=	go:modules \
+		pkgconfig # trailing comment
+.  if ${ARCH} == i386
+.include "${.CURDIR}/Makefile.common"
+.endif
+.for f in a b
+.endfor
+.PHONY: do-build
+
+do-build:
+	@${ECHO} FOO=bar
+
+.include <bsd.port.mk>
+`
+
+	expected := []struct {
+		typ   TokenType
+		name  string
+		op    string
+		value string
+		line  int
+	}{
+		{Tcomment, "", "", "# comment", 1},
+		{Tassignment, "PORTNAME", "=", "foo", 2},
+		{Tassignment, "USES", "=", "go:modules pkgconfig", 3},
+		{Tconditional, "if", "", "${ARCH} == i386", 5},
+		{Tinclude, "include", "", "${.CURDIR}/Makefile.common", 6},
+		{Tconditional, "endif", "", "", 7},
+		{Tdirective, "for", "", "f in a b", 8},
+		{Tdirective, "endfor", "", "", 9},
+		{Ttarget, ".PHONY", ":", "do-build", 10},
+		{Ttarget, "do-build", ":", "", 12},
+		{Trecipe, "", "", "@${ECHO} FOO=bar", 13},
+		{Tinclude, "include", "", "bsd.port.mk", 15},
+	}
+
+	toks := Tokenize([]byte(src))
+	if len(toks) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(toks))
+	}
+	for i, tok := range toks {
+		x := expected[i]
+		if tok.Type != x.typ || tok.Name != x.name || tok.Op != x.op || tok.Value != x.value || tok.Line != x.line {
+			t.Errorf("[#%d] expected %v %q %q %q at line %d, got %v %q %q %q at line %d",
+				i, x.typ, x.name, x.op, x.value, x.line, tok.Type, tok.Name, tok.Op, tok.Value, tok.Line)
+		}
+	}
+
+	// source positions of assignment parts
+	uses := toks[2]
+	if s := src[uses.NameOffset : uses.NameOffset+len(uses.Name)]; s != "USES" {
+		t.Errorf("expected name at NameOffset, got %q", s)
+	}
+	if s := src[uses.ValueOffset:uses.ValueEnd]; s != "go:modules \\\n\t\tpkgconfig" {
+		t.Errorf("expected raw value, got %q", s)
+	}
+	var words []string
+	for _, w := range uses.Words {
+		if src[w.Offset:w.End] != w.Text {
+			t.Errorf("expected word %q at offset %d, got %q", w.Text, w.Offset, src[w.Offset:w.End])
+		}
+		words = append(words, w.Text)
+	}
+	if len(words) != 2 || words[0] != "go:modules" || words[1] != "pkgconfig" {
+		t.Errorf("expected value words [go:modules pkgconfig], got %q", words)
+	}
+	if s := src[uses.Offset:uses.End]; s != "USES	=	go:modules \\\n\t\tpkgconfig # trailing comment" {
+		t.Errorf("expected token text, got %q", s)
+	}
+}
@@ -0,0 +1,374 @@
+package makefile
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Vars holds make variables and expands references to them.
+type Vars struct {
+	vals map[string]string
+}
+
+// NewVars returns an empty variable set.
+func NewVars() *Vars {
+	return &Vars{vals: make(map[string]string)}
+}
+
+// Apply applies assignment a to the variable set.  "!=" assignments are
+// ignored, since expanding them requires running shell commands.
+func (v *Vars) Apply(a *Assignment) {
+	name := v.Expand(a.Name)
+
+	switch a.Op {
+	case "=":
+		v.vals[name] = a.Value
+	case "+=":
+		if old, ok := v.vals[name]; ok && old != "" {
+			v.vals[name] = old + " " + a.Value
+		} else {
+			v.vals[name] = a.Value
+		}
+	case "?=":
+		if _, ok := v.vals[name]; !ok {
+			v.vals[name] = a.Value
+		}
+	case ":=":
+		v.vals[name] = v.Expand(a.Value)
+	}
+}
+
+// Set sets the variable name to the unexpanded value.
+func (v *Vars) Set(name, value string) {
+	v.vals[name] = value
+}
+
+// Get returns the unexpanded value of the variable name, and reports whether
+// it's defined.
+func (v *Vars) Get(name string) (string, bool) {
+	val, ok := v.vals[name]
+	return val, ok
+}
+
+// Value returns the expanded value of the variable name.
+func (v *Vars) Value(name string) string {
+	return v.Expand(v.vals[name])
+}
+
+// Expand expands variable references like ${VAR} or $(VAR) in s, applying
+// variable modifiers :S, :C, :M, :N, :tl, :tu, :H and :T.  "$$" expands to
+// "$".  References to undefined variables, or using unsupported modifiers,
+// are left unexpanded.  Recursive references, like "FOO=	${FOO} -O2", are
+// left unexpanded too, like make(1) refuses to expand them.
+func (v *Vars) Expand(s string) string {
+	return v.expand(s, make(map[string]struct{}))
+}
+
+// expand expands variable references in s, active holds names of variables
+// being expanded.
+func (v *Vars) expand(s string, active map[string]struct{}) string {
+	if strings.IndexByte(s, '$') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{', '(':
+			end := closingBrace(s, i+1)
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			if val, ok := v.expandRef(s[i+2:end], active); ok {
+				b.WriteString(val)
+			} else {
+				b.WriteString(s[i : end+1])
+			}
+			i = end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String()
+}
+
+// closingBrace returns the index of the brace closing the one at s[i], or -1.
+func closingBrace(s string, i int) int {
+	open, close := s[i], byte('}')
+	if open == '(' {
+		close = ')'
+	}
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// expandRef expands the contents of a variable reference, like "VAR:tl".
+func (v *Vars) expandRef(ref string, active map[string]struct{}) (string, bool) {
+	ref = v.expand(ref, active)
+
+	name, mods := ref, ""
+	if i := strings.IndexByte(ref, ':'); i >= 0 {
+		name, mods = ref[:i], ref[i+1:]
+	}
+
+	raw, ok := v.vals[name]
+	if !ok {
+		return "", false
+	}
+	if _, ok := active[name]; ok {
+		return "", false // recursive reference
+	}
+	active[name] = struct{}{}
+	val := v.expand(raw, active)
+	delete(active, name)
+
+	for mods != "" {
+		var err bool
+		val, mods, err = applyModifier(val, mods)
+		if err {
+			return "", false
+		}
+	}
+	return val, true
+}
+
+// applyModifier applies the first modifier in mods to val, and returns the
+// result and remaining modifiers.  It reports true if the modifier is not
+// supported or malformed.
+func applyModifier(val, mods string) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(mods, "tl"):
+		return strings.ToLower(val), nextModifier(mods[2:]), false
+	case strings.HasPrefix(mods, "tu"):
+		return strings.ToUpper(val), nextModifier(mods[2:]), false
+	case strings.HasPrefix(mods, "H"):
+		return mapWords(val, path.Dir), nextModifier(mods[1:]), false
+	case strings.HasPrefix(mods, "T"):
+		return mapWords(val, path.Base), nextModifier(mods[1:]), false
+	case strings.HasPrefix(mods, "M"), strings.HasPrefix(mods, "N"):
+		pat, rest := splitModifier(mods[1:])
+		re, err := globRegexp(pat)
+		if err != nil {
+			return "", "", true
+		}
+		keep := mods[0] == 'M'
+		var words []string
+		for _, w := range strings.Fields(val) {
+			if re.MatchString(w) == keep {
+				words = append(words, w)
+			}
+		}
+		return strings.Join(words, " "), rest, false
+	case strings.HasPrefix(mods, "S"), strings.HasPrefix(mods, "C"):
+		return substitute(val, mods)
+	}
+	return "", "", true
+}
+
+// nextModifier returns modifiers following the current one, or "" if there
+// are none.
+func nextModifier(mods string) string {
+	return strings.TrimPrefix(mods, ":")
+}
+
+// splitModifier splits mods at the first unescaped ":", returning the
+// current modifier argument with escapes removed and remaining modifiers.
+func splitModifier(mods string) (string, string) {
+	var b strings.Builder
+	for i := 0; i < len(mods); i++ {
+		if mods[i] == '\\' && i+1 < len(mods) && mods[i+1] == ':' {
+			b.WriteByte(':')
+			i++
+			continue
+		}
+		if mods[i] == ':' {
+			return b.String(), mods[i+1:]
+		}
+		b.WriteByte(mods[i])
+	}
+	return b.String(), ""
+}
+
+func mapWords(val string, fn func(string) string) string {
+	words := strings.Fields(val)
+	for i, w := range words {
+		words[i] = fn(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// substitute applies :S/old/new/[1gW] or :C/regexp/new/[1gW] modifier.
+func substitute(val, mods string) (string, string, bool) {
+	if len(mods) < 2 {
+		return "", "", true
+	}
+	kind, delim := mods[0], mods[1]
+
+	// split into old, new and flags
+	var parts []string
+	var b strings.Builder
+	i := 2
+	for ; i < len(mods) && len(parts) < 2; i++ {
+		c := mods[i]
+		if c == '\\' && i+1 < len(mods) && mods[i+1] == delim {
+			b.WriteByte(delim)
+			i++
+			continue
+		}
+		if c == delim {
+			parts = append(parts, b.String())
+			b.Reset()
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if len(parts) < 2 {
+		return "", "", true
+	}
+	flags, rest := mods[i:], ""
+	if j := strings.IndexByte(flags, ':'); j >= 0 {
+		flags, rest = flags[:j], flags[j+1:]
+	}
+	global := strings.Contains(flags, "g")
+	once := strings.Contains(flags, "1")
+
+	var re *regexp.Regexp
+	if kind == 'C' {
+		var err error
+		re, err = regexp.Compile(parts[0])
+		if err != nil {
+			return "", "", true
+		}
+	} else {
+		re = substRegexp(parts[0])
+	}
+
+	words := []string{val}
+	if !strings.Contains(flags, "W") {
+		words = strings.Fields(val)
+	}
+
+	done := false
+	for k, w := range words {
+		if done {
+			break
+		}
+		words[k] = replace(re, w, parts[1], global, kind == 'C')
+		if once && words[k] != w {
+			done = true
+		}
+	}
+
+	return strings.Join(words, " "), rest, false
+}
+
+// substRegexp converts :S modifier pattern, where only "^" and "$" anchors
+// are special, to a regular expression.
+func substRegexp(pat string) *regexp.Regexp {
+	var pre, post string
+	if strings.HasPrefix(pat, "^") {
+		pre, pat = "^", pat[1:]
+	}
+	if strings.HasSuffix(pat, "$") {
+		post, pat = "$", pat[:len(pat)-1]
+	}
+	return regexp.MustCompile(pre + regexp.QuoteMeta(pat) + post)
+}
+
+// replace replaces the first, or all if global is true, matches of re in s
+// with repl.  In repl, "&" is replaced by the whole match and, if
+// submatches is true, "\1" to "\9" by the corresponding submatch.
+func replace(re *regexp.Regexp, s, repl string, global, submatches bool) string {
+	n := 1
+	if global {
+		n = -1
+	}
+	ms := re.FindAllStringSubmatchIndex(s, n)
+	if ms == nil {
+		return s
+	}
+
+	var b strings.Builder
+	pos := 0
+	for _, m := range ms {
+		b.WriteString(s[pos:m[0]])
+		for i := 0; i < len(repl); i++ {
+			c := repl[i]
+			switch {
+			case c == '\\' && i+1 < len(repl):
+				d := repl[i+1]
+				if submatches && d >= '0' && d <= '9' {
+					if g := int(d - '0'); 2*g+1 < len(m) && m[2*g] >= 0 {
+						b.WriteString(s[m[2*g]:m[2*g+1]])
+					}
+				} else {
+					b.WriteByte(d)
+				}
+				i++
+			case c == '&':
+				b.WriteString(s[m[0]:m[1]])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		pos = m[1]
+	}
+	b.WriteString(s[pos:])
+	return b.String()
+}
+
+// globRegexp converts :M/:N shell glob pattern to a regular expression.
+func globRegexp(pat string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '[':
+			j := strings.IndexByte(pat[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pat[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case '\\':
+			if i+1 < len(pat) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
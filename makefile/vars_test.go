@@ -0,0 +1,117 @@
+package makefile
+
+import (
+	"testing"
+)
+
+func TestParseAssignments(t *testing.T) {
+	src := []byte(`PORTNAME=	foo # comment
+PORTVERSION?=	1.0
+LIB_DEPENDS=	libfoo.so:devel/foo \
+		libbar.so:devel/bar
+USES+=		go
+.if ${ARCH} == i386
+CFLAGS:=	-O0
+.endif
+SHELL_VAR!=	echo foo
+COMMENT=	Foo \# bar
+${PORTNAME}_VAR=	x
+
+do-build:
+	@${ECHO} FOO=bar
+`)
+
+	expected := []Assignment{
+		{"PORTNAME", "=", "foo", 1},
+		{"PORTVERSION", "?=", "1.0", 2},
+		{"LIB_DEPENDS", "=", "libfoo.so:devel/foo libbar.so:devel/bar", 3},
+		{"USES", "+=", "go", 5},
+		{"CFLAGS", ":=", "-O0", 7},
+		{"SHELL_VAR", "!=", "echo foo", 9},
+		{"COMMENT", "=", "Foo # bar", 10},
+		{"${PORTNAME}_VAR", "=", "x", 11},
+	}
+
+	as := ParseAssignments(src)
+	if len(as) != len(expected) {
+		t.Fatalf("expected %d assignments, got %d: %v", len(expected), len(as), as)
+	}
+	for i, a := range as {
+		if *a != expected[i] {
+			t.Errorf("[#%d] expected %+v, got %+v", i, expected[i], *a)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	v := NewVars()
+	for _, a := range ParseAssignments([]byte(`PORTNAME=	Foo
+DISTVERSION=	1.2.3
+WRKSRC=		/wrk/${PORTNAME:tl}-${DISTVERSION}
+USES=		go:modules gmake pkgconfig
+USES+=		python
+SELF=		${SELF}
+`)) {
+		v.Apply(a)
+	}
+
+	examples := []struct {
+		s   string
+		exp string
+	}{
+		{"lib${PORTNAME}.so", "libFoo.so"},
+		{"lib$(PORTNAME).so", "libFoo.so"},
+		{"${PORTNAME:tl}", "foo"},
+		{"${PORTNAME:tu}", "FOO"},
+		{"${WRKSRC}", "/wrk/foo-1.2.3"},
+		{"${WRKSRC:H}", "/wrk"},
+		{"${WRKSRC:T}", "foo-1.2.3"},
+		{"${USES}", "go:modules gmake pkgconfig python"},
+		{"${USES:Mgo*}", "go:modules"},
+		{"${USES:Ngo*:Np*}", "gmake"},
+		{"${USES:S/go/golang/}", "golang:modules gmake pkgconfig python"},
+		{"${USES:S/^g/x/g}", "xo:modules xmake pkgconfig python"},
+		{"${USES:S/o/0/g:M*0*}", "g0:m0dules pkgc0nfig pyth0n"},
+		{"${DISTVERSION:C/([0-9]+)\\.([0-9]+).*/\\2.\\1/}", "2.1"},
+		{"${DISTVERSION:S/./_/g}", "1_2_3"},
+		{"${DISTVERSION:S/$/-rc/}", "1.2.3-rc"},
+		{"${PORTNAME:S/Foo/&-bar/}", "Foo-bar"},
+		{"${UNDEFINED}-${PORTNAME}", "${UNDEFINED}-Foo"},
+		{"${PORTNAME:Q}", "${PORTNAME:Q}"},
+		{"$${PORTNAME}", "${PORTNAME}"},
+		{"${SELF}", "${SELF}"},
+	}
+
+	for i, x := range examples {
+		if s := v.Expand(x.s); s != x.exp {
+			t.Errorf("[#%d] expected %q to expand to %q, got %q", i, x.s, x.exp, s)
+		}
+	}
+}
+
+func TestExpandRecursive(t *testing.T) {
+	v := NewVars()
+	for _, a := range ParseAssignments([]byte(`FOO=	${FOO}${FOO}
+A=	${B} ${B}
+B=	${A} ${A}
+CFLAGS=	-O2 -pipe
+CFLAGS+=	${CFLAGS:M-O*} ${CFLAGS:N-O*}
+`)) {
+		v.Apply(a)
+	}
+
+	examples := []struct {
+		s   string
+		exp string
+	}{
+		{"${FOO}", "${FOO}${FOO}"},
+		{"${A}", "${A} ${A} ${A} ${A}"},
+		{"${CFLAGS}", "-O2 -pipe ${CFLAGS:M-O*} ${CFLAGS:N-O*}"},
+	}
+
+	for i, x := range examples {
+		if s := v.Expand(x.s); s != x.exp {
+			t.Errorf("[#%d] expected %q to expand to %q, got %q", i, x.s, x.exp, s)
+		}
+	}
+}